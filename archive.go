@@ -1,14 +1,17 @@
 package gitbase
 
 import (
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
 	"strings"
 
 	"io/ioutil"
+	"path"
 	"path/filepath"
 )
 
@@ -29,7 +32,7 @@ func (self *Archive) Path() string {
 	if self.Collection == nil {
 		return fmt.Sprintf("%d", self.Id)
 	}
-	path := filepath.Join(self.Collection.Path(), string(self.Id))
+	path := filepath.Join(self.Collection.Path(), fmt.Sprintf("%d", self.Id))
 	return path
 }
 
@@ -39,21 +42,16 @@ func ArchivePath(collection *Collection, id uint64) string {
 }
 
 func OpenArchive(collection *Collection, id uint64) (*Archive, error) {
-	path := collection.Path()
+	archive := &Archive{
+		Id:         id,
+		Collection: collection,
+	}
 
 	// Try to open path
-	archivePath := filepath.Join(path, fmt.Sprintf("%d", id))
-
-	fh, err := os.Open(archivePath)
+	_, err := collection.Repository.readDir(archive.Key(""))
 	if err != nil {
 		return nil, ErrArchiveDoesNotExist
 	}
-	defer fh.Close()
-
-	archive := &Archive{
-		Id:         id,
-		Collection: collection,
-	}
 
 	return archive, nil
 }
@@ -64,7 +62,8 @@ Calculate next archive id
 func NextArchiveId(collection *Collection) uint64 {
 	archives, err := ListArchives(collection)
 	if err != nil {
-		log.Println(err)
+		collection.Repository.logger().Error("could not list archives",
+			"collection", collection.Name, "error", err)
 		return 1
 	}
 
@@ -83,15 +82,8 @@ List Archives
 */
 func ListArchives(collection *Collection) ([]*Archive, error) {
 	archives := []*Archive{}
-	path := collection.Path()
 
-	f, err := os.Open(path)
-	if err != nil {
-		return archives, err
-	}
-	defer f.Close()
-
-	items, err := f.Readdir(0)
+	items, err := collection.Repository.readDir(collection.Name)
 	if err != nil {
 		return archives, err
 	}
@@ -107,8 +99,9 @@ func ListArchives(collection *Collection) ([]*Archive, error) {
 
 		archiveId, err := strconv.ParseUint(item.Name(), 10, 64)
 		if err != nil {
-			log.Println("Found non numeric entry in archives path:", item.Name())
-			log.Println("Please check if the repository is OK.")
+			collection.Repository.logger().Warn(
+				"non numeric entry in archives path, check the repository with Verify",
+				"collection", collection.Name, "entry", item.Name())
 			continue
 		}
 
@@ -127,20 +120,16 @@ func ListArchives(collection *Collection) ([]*Archive, error) {
  List documents
 */
 func (self *Archive) Documents() ([]string, error) {
-	documents := []string{}
-
-	path := filepath.Join(
-		self.Collection.Path(),
-		fmt.Sprintf("%d", self.Id),
-	)
+	return self.DocumentsContext(context.Background())
+}
 
-	f, err := os.Open(path)
-	if err != nil {
+func (self *Archive) DocumentsContext(ctx context.Context) ([]string, error) {
+	documents := []string{}
+	if err := ctx.Err(); err != nil {
 		return documents, err
 	}
-	defer f.Close()
 
-	items, err := f.Readdir(0)
+	items, err := self.Collection.Repository.readDir(self.Key(""))
 	if err != nil {
 		return documents, err
 	}
@@ -160,12 +149,61 @@ func (self *Archive) Documents() ([]string, error) {
 	return documents, nil
 }
 
+/*
+ List documents of a specific revision. The revision
+ may be any revision accepted by FetchRevision,
+ including snapshot names.
+*/
+func (self *Archive) DocumentsRevision(rev string) ([]string, error) {
+	return self.DocumentsRevisionContext(context.Background(), rev)
+}
+
+func (self *Archive) DocumentsRevisionContext(
+	ctx context.Context, rev string,
+) ([]string, error) {
+	documents := []string{}
+	if err := ctx.Err(); err != nil {
+		return documents, err
+	}
+
+	tree, err := self.Collection.Repository.revisionTree(rev)
+	if err != nil {
+		return documents, err
+	}
+
+	archiveTree, err := tree.Tree(self.Key(""))
+	if err == object.ErrDirectoryNotFound {
+		return documents, ErrArchiveDoesNotExist
+	}
+	if err != nil {
+		return documents, err
+	}
+
+	for _, entry := range archiveTree.Entries {
+		if !entry.Mode.IsFile() {
+			continue
+		}
+
+		if strings.HasPrefix(entry.Name, ".") {
+			continue
+		}
+
+		documents = append(documents, entry.Name)
+	}
+
+	return documents, nil
+}
+
 /*
  Remove archive
 */
-func (self *Archive) Destroy(reason string) error {
-	log.Println("Destroying archive id:", fmt.Sprintf("%d", self.Id))
+func (self *Archive) Destroy(reason string, opts ...WriteOption) error {
+	return self.DestroyContext(context.Background(), reason, opts...)
+}
 
+func (self *Archive) DestroyContext(
+	ctx context.Context, reason string, opts ...WriteOption,
+) error {
 	// Fall back to default reason if required
 	if reason == "" {
 		reason = "removed archive id: " + fmt.Sprintf("%d", self.Id)
@@ -183,8 +221,20 @@ func (self *Archive) Destroy(reason string) error {
 	defer fh.Close()
 
 	// Disallow write access to repository
-	self.Collection.Repository.Lock()
-	defer self.Collection.Repository.Unlock()
+	if err := self.Collection.Repository.lockContext(ctx); err != nil {
+		return err
+	}
+	defer self.Collection.Repository.unlock()
+
+	repo := self.Collection.Repository
+	repo.logger().Info("destroying archive",
+		"collection", self.Collection.Name, "archive_id", self.Id)
+
+	// Documents to restore if the commit fails
+	keys, err := repo.headKeys(filepath.FromSlash(self.Key("")))
+	if err != nil {
+		return err
+	}
 
 	// Remove from filesystem
 	err = os.RemoveAll(path)
@@ -193,38 +243,62 @@ func (self *Archive) Destroy(reason string) error {
 	}
 
 	// Commit this change
-	err = self.Collection.Repository.CommitAll(reason)
-	return err
+	return repo.commitOrRestore(reason, keys, opts...)
 }
 
 /*
  Create a new archive with a new id
 */
-func NextArchive(collection *Collection, reason string) (*Archive, error) {
-	nextId := NextArchiveId(collection)
-	path := ArchivePath(collection, nextId)
+func NextArchive(
+	collection *Collection, reason string, opts ...WriteOption,
+) (*Archive, error) {
+	return NextArchiveContext(
+		context.Background(), collection, reason, opts...)
+}
+
+func NextArchiveContext(
+	ctx context.Context,
+	collection *Collection,
+	reason string,
+	opts ...WriteOption,
+) (*Archive, error) {
+	if err := collection.Repository.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer collection.Repository.unlock()
 
-	collection.Repository.Lock()
-	defer collection.Repository.Unlock()
+	nextId := NextArchiveId(collection)
 
 	// Create if not exists
-	err := os.MkdirAll(path, 0755)
+	err := createArchivePath(collection, nextId)
 	if err != nil {
 		return nil, err
 	}
 
-	gitkeep := filepath.Join(path, ".gitkeep")
-	err = ioutil.WriteFile(gitkeep, []byte{}, 0644)
+	archive := &Archive{Collection: collection, Id: nextId}
+	gitkeep := filepath.FromSlash(archive.Key(".gitkeep"))
+	err = collection.Repository.commitOrRestore(
+		reason, []string{gitkeep}, opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	err = collection.Repository.CommitAll(reason)
+	return OpenArchive(collection, nextId)
+}
+
+/*
+ Create the archive in the worktree without committing.
+ The caller must hold the repository lock.
+*/
+func createArchivePath(collection *Collection, id uint64) error {
+	path := ArchivePath(collection, id)
+	err := os.MkdirAll(path, 0755)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return OpenArchive(collection, nextId)
+	gitkeep := filepath.Join(path, ".gitkeep")
+	return ioutil.WriteFile(gitkeep, []byte{}, 0644)
 }
 
 // Alias
@@ -234,50 +308,204 @@ var CreateArchive = NextArchive
 // Wrap document functions
 //
 
+/*
+ Get the repository key of a document in this archive
+*/
+func (self *Archive) Key(key string) string {
+	return path.Join(self.Collection.Name, fmt.Sprintf("%d", self.Id), key)
+}
+
+/*
+ Validate the document name and get the repository key
+*/
+func (self *Archive) documentKey(key string) (string, error) {
+	if err := ValidateName(key); err != nil {
+		return "", err
+	}
+	return self.Key(key), nil
+}
+
 /*
  Create / Update document, see Repository.Put
 */
-func (self *Archive) Put(key string, document []byte, reason string) error {
-	path := filepath.Join(self.Collection.Name, fmt.Sprintf("%d", self.Id), key)
-	return self.Collection.Repository.Put(path, document, reason)
+func (self *Archive) Put(
+	key string, document []byte, reason string, opts ...WriteOption,
+) error {
+	return self.PutContext(
+		context.Background(), key, document, reason, opts...)
+}
+
+func (self *Archive) PutContext(
+	ctx context.Context,
+	key string,
+	document []byte,
+	reason string,
+	opts ...WriteOption,
+) error {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return err
+	}
+	return self.Collection.Repository.PutContext(
+		ctx, path, document, reason, opts...)
+}
+
+/*
+ Conditionally update document, see Repository.PutIfRevision
+*/
+func (self *Archive) PutIfRevision(
+	key string,
+	document []byte,
+	expectedRev string,
+	reason string,
+	opts ...WriteOption,
+) (string, error) {
+	return self.PutIfRevisionContext(
+		context.Background(), key, document, expectedRev, reason, opts...)
+}
+
+func (self *Archive) PutIfRevisionContext(
+	ctx context.Context,
+	key string,
+	document []byte,
+	expectedRev string,
+	reason string,
+	opts ...WriteOption,
+) (string, error) {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return "", err
+	}
+	return self.Collection.Repository.PutIfRevisionContext(
+		ctx, path, document, expectedRev, reason, opts...)
 }
 
 /*
  Remove document, see: Repository.Remove
 */
-func (self *Archive) Remove(key, reason string) error {
-	path := filepath.Join(self.Collection.Name, fmt.Sprintf("%d", self.Id), key)
-	return self.Collection.Repository.Remove(path, reason)
+func (self *Archive) Remove(key, reason string, opts ...WriteOption) error {
+	return self.RemoveContext(context.Background(), key, reason, opts...)
+}
+
+func (self *Archive) RemoveContext(
+	ctx context.Context, key, reason string, opts ...WriteOption,
+) error {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return err
+	}
+	return self.Collection.Repository.RemoveContext(ctx, path, reason, opts...)
 }
 
 /*
  Fetch, see Repository.Fetch
 */
 func (self *Archive) Fetch(key string) ([]byte, error) {
-	path := filepath.Join(self.Collection.Name, fmt.Sprintf("%d", self.Id), key)
-	return self.Collection.Repository.Fetch(path)
+	return self.FetchContext(context.Background(), key)
+}
+
+func (self *Archive) FetchContext(
+	ctx context.Context, key string,
+) ([]byte, error) {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return self.Collection.Repository.FetchContext(ctx, path)
 }
 
 /*
  Fetch revision, see Repository.FetchRevision
 */
 func (self *Archive) FetchRevision(key, rev string) ([]byte, error) {
-	path := filepath.Join(self.Collection.Name, fmt.Sprintf("%d", self.Id), key)
-	return self.Collection.Repository.FetchRevision(path, rev)
+	return self.FetchRevisionContext(context.Background(), key, rev)
+}
+
+func (self *Archive) FetchRevisionContext(
+	ctx context.Context, key, rev string,
+) ([]byte, error) {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return self.Collection.Repository.FetchRevisionContext(ctx, path, rev)
+}
+
+/*
+ Diff document, see Repository.Diff
+*/
+func (self *Archive) Diff(key, fromRev, toRev string) (*DocumentDiff, error) {
+	return self.DiffContext(context.Background(), key, fromRev, toRev)
+}
+
+func (self *Archive) DiffContext(
+	ctx context.Context, key, fromRev, toRev string,
+) (*DocumentDiff, error) {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return self.Collection.Repository.DiffContext(ctx, path, fromRev, toRev)
+}
+
+/*
+ Diff all documents of the archive, see Repository.DiffAll
+*/
+func (self *Archive) DiffAll(fromRev, toRev string) ([]*DocumentDiff, error) {
+	return self.DiffAllContext(context.Background(), fromRev, toRev)
+}
+
+func (self *Archive) DiffAllContext(
+	ctx context.Context, fromRev, toRev string,
+) ([]*DocumentDiff, error) {
+	diffs, err := self.Collection.Repository.DiffAllContext(
+		ctx, self.Key(""), fromRev, toRev)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip hidden files like .gitkeep
+	documents := []*DocumentDiff{}
+	for _, documentDiff := range diffs {
+		if strings.HasPrefix(path.Base(documentDiff.Key), ".") {
+			continue
+		}
+		documents = append(documents, documentDiff)
+	}
+
+	return documents, nil
 }
 
 /*
  Get commit History, see Repository.History
 */
 func (self *Archive) History(key string) ([]*Commit, error) {
-	path := filepath.Join(self.Collection.Name, fmt.Sprintf("%d", self.Id), key)
-	return self.Collection.Repository.History(path)
+	return self.HistoryContext(context.Background(), key)
+}
+
+func (self *Archive) HistoryContext(
+	ctx context.Context, key string,
+) ([]*Commit, error) {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return self.Collection.Repository.HistoryContext(ctx, path)
 }
 
 /*
  Get revisions, see Repository.Revisions
 */
 func (self *Archive) Revisions(key string) ([]string, error) {
-	path := filepath.Join(self.Collection.Name, fmt.Sprintf("%d", self.Id), key)
-	return self.Collection.Repository.Revisions(path)
+	return self.RevisionsContext(context.Background(), key)
+}
+
+func (self *Archive) RevisionsContext(
+	ctx context.Context, key string,
+) ([]string, error) {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return self.Collection.Repository.RevisionsContext(ctx, path)
 }
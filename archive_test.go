@@ -169,3 +169,107 @@ func TestArchiveDocumentHandling(t *testing.T) {
 		t.Error("Expected foo, got:", string(res))
 	}
 }
+
+func TestArchivePutIfRevision(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	collection, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := collection.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Create document, it must not exist
+	rev1, err := archive.PutIfRevision(
+		"source.lua", []byte("x = 1"), "", "add source")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Creating it again should fail
+	current, err := archive.PutIfRevision(
+		"source.lua", []byte("x = 1"), "", "add source")
+	if err != ErrConflict {
+		t.Error("Expected ErrConflict, got:", err)
+	}
+	if current != rev1 {
+		t.Error("Expected current revision:", rev1, "got:", current)
+	}
+
+	// Two editors load revision 1, the first one wins
+	rev2, err := archive.PutIfRevision(
+		"source.lua", []byte("x = 2"), rev1, "first editor")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	current, err = archive.PutIfRevision(
+		"source.lua", []byte("x = 3"), rev1, "second editor")
+	if err != ErrConflict {
+		t.Error("Expected ErrConflict, got:", err)
+	}
+	if current != rev2 {
+		t.Error("Expected current revision:", rev2, "got:", current)
+	}
+
+	res, err := archive.Fetch("source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "x = 2" {
+		t.Error("Expected first edit to be kept, got:", string(res))
+	}
+
+	revs, err := archive.Revisions("source.lua")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if revs[0] != rev2 {
+		t.Error("Expected latest revision:", rev2, "got:", revs[0])
+	}
+
+	// Short hashes and symbolic revisions are resolved
+	rev3, err := archive.PutIfRevision(
+		"source.lua", []byte("x = 4"), rev2[:7], "short hash")
+	if err != nil {
+		t.Error("Expected short hash to match, got:", err)
+		return
+	}
+	_, err = archive.PutIfRevision(
+		"source.lua", []byte("x = 5"), "HEAD", "symbolic")
+	if err != nil {
+		t.Error("Expected HEAD to match, got:", err)
+		return
+	}
+	current, err = archive.PutIfRevision(
+		"source.lua", []byte("x = 6"), rev3, "outdated")
+	if err != ErrConflict {
+		t.Error("Expected ErrConflict, got:", err)
+	}
+
+	// Removed documents do not exist
+	if err := archive.Remove("source.lua", "remove source"); err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = archive.PutIfRevision(
+		"source.lua", []byte("x = 1"), "", "recreate source")
+	if err != nil {
+		t.Error("Expected removed document to be created, got:", err)
+	}
+}
@@ -0,0 +1,234 @@
+package gitbase
+
+/*
+Atomic writes:
+Documents are written to a temporary file in the same
+directory, which is renamed to the document path when
+complete. A crash during a write never leaves a
+truncated document behind.
+
+Only the documents touched by an operation are staged
+and committed, other changes in the worktree are left
+as they are. If committing a change fails, the touched
+documents are restored in the worktree and in the index
+to the state of HEAD, so the worktree does not diverge
+from the history.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Temporary files are hidden and not listed as documents
+const atomicTempPrefix = ".gitbase-tmp-"
+
+/*
+ Write the content of the reader to a temporary
+ file and rename it to the path.
+*/
+func writeFileAtomic(path string, reader io.Reader) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), atomicTempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails after rename
+
+	_, err = io.Copy(tmp, reader)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+/*
+ Stage and commit the changes of the documents, other
+ changes in the worktree are not committed. If the commit
+ fails, the documents are restored to HEAD.
+ The caller must hold the repository lock.
+*/
+func (self *Repository) commitOrRestore(
+	reason string, keys []string, opts ...WriteOption,
+) error {
+	err := self.stageDocuments(keys...)
+	if err == nil {
+		err = self.Commit(reason, opts...)
+	}
+	if err == nil || isCommitted(err) {
+		return err
+	}
+
+	// The commit error is more relevant to the caller
+	if rerr := self.restoreHead(keys...); rerr != nil {
+		self.logger().Error("could not restore documents after failed commit",
+			"reason", reason, "error", rerr)
+	}
+
+	return err
+}
+
+/*
+ Get the keys of all documents below a path in HEAD,
+ e.g. to restore a removed archive.
+*/
+func (self *Repository) headKeys(path string) ([]string, error) {
+	tree, err := self.headTree()
+	if err != nil || tree == nil {
+		return nil, err
+	}
+
+	dir, err := tree.Tree(filepath.ToSlash(path))
+	if err == object.ErrDirectoryNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	err = dir.Files().ForEach(func(file *object.File) error {
+		keys = append(keys, filepath.Join(path, filepath.FromSlash(file.Name)))
+		return nil
+	})
+
+	return keys, err
+}
+
+/*
+ Restore documents in the worktree and the index to the
+ state of HEAD. Documents not in HEAD are removed.
+ The caller must hold the repository lock.
+*/
+func (self *Repository) restoreHead(keys ...string) error {
+	if err := self.restoreWorktree(keys...); err != nil {
+		return err
+	}
+
+	return self.stageDocuments(keys...)
+}
+
+func (self *Repository) restoreWorktree(keys ...string) error {
+	// Without a HEAD tree, all documents are new
+	tree, err := self.headTree()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := self.restoreDocument(tree, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *Repository) restoreDocument(tree *object.Tree, key string) error {
+	path := filepath.Join(self.BasePath, key)
+
+	var file *object.File
+	if tree != nil {
+		file, _ = tree.File(filepath.ToSlash(key))
+	}
+	if file == nil {
+		// The document did not exist before
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		self.removeEmptyDirs(filepath.Clean(key))
+		return nil
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return self.writeStoredDocument(key, []byte(content))
+}
+
+/*
+ Remove the directories of a removed document,
+ as long as they are empty.
+*/
+func (self *Repository) removeEmptyDirs(key string) {
+	for dir := filepath.Dir(key); dir != "."; dir = filepath.Dir(dir) {
+		if err := os.Remove(filepath.Join(self.BasePath, dir)); err != nil {
+			return // Not empty
+		}
+	}
+}
+
+/*
+ Update the index entries of the documents
+ to match the worktree.
+*/
+func (self *Repository) stageDocuments(keys ...string) error {
+	staged := map[string]bool{}
+	for _, key := range keys {
+		if staged[key] {
+			continue
+		}
+		staged[key] = true
+
+		path := filepath.Join(self.BasePath, key)
+		if _, err := os.Stat(path); err == nil {
+			if _, err := self.Worktree.Add(key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		_, err := self.Worktree.Remove(key)
+		if err != nil && err != index.ErrEntryNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ Remove paths from the index, the files in
+ the worktree are kept.
+*/
+func (self *Repository) unstagePaths(keys ...string) error {
+	idx, err := self.gitRepo.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		_, err := idx.Remove(filepath.ToSlash(key))
+		if err != nil && err != index.ErrEntryNotFound {
+			return err
+		}
+	}
+
+	return self.gitRepo.Storer.SetIndex(idx)
+}
@@ -0,0 +1,271 @@
+package gitbase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitbase-test-atomic")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "doc")
+	for _, content := range []string{"first", "second"} {
+		err := writeFileAtomic(path, strings.NewReader(content))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, _ := ioutil.ReadFile(path)
+		if string(res) != content {
+			t.Error("Unexpected content:", string(res))
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0644 {
+		t.Error("Unexpected file mode:", info, err)
+	}
+
+	// No temporary files are left behind
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Error("Expected 1 file, got:", len(files))
+	}
+}
+
+func TestRepositoryRestoreOnCommitFailure(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	if err := repo.Put("doc", []byte("committed"), "add doc"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Committing fails with invalid metadata
+	invalid := WithMetadata(map[string]string{"Bad Key": "value"})
+
+	assertClean := func(step string) {
+		status, err := repo.Worktree.Status()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !status.IsClean() {
+			t.Error(step, "expected clean worktree, got:", status)
+		}
+	}
+
+	// Update
+	err = repo.Put("doc", []byte("update"), "update doc", invalid)
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	res, _ := repo.Fetch("doc")
+	if string(res) != "committed" {
+		t.Error("Expected restored document, got:", string(res))
+	}
+	assertClean("update:")
+
+	// New documents are removed
+	err = repo.Put("new", []byte("new"), "add new", invalid)
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "new")); !os.IsNotExist(err) {
+		t.Error("Expected new document to be removed:", err)
+	}
+	assertClean("create:")
+
+	// Conditional put
+	rev, _ := repo.headRevision()
+	_, err = repo.PutIfRevision("doc", []byte("update"), rev, "update doc", invalid)
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	assertClean("conditional update:")
+
+	// Streaming
+	err = repo.PutReader(
+		"doc", bytes.NewReader([]byte("stream")), "update doc", invalid)
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	assertClean("streaming update:")
+
+	// Removed documents are restored
+	err = repo.Remove("doc", "remove doc", invalid)
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	res, _ = repo.Fetch("doc")
+	if string(res) != "committed" {
+		t.Error("Expected restored document, got:", string(res))
+	}
+	assertClean("remove:")
+
+	// Unrelated changes are not touched
+	err = ioutil.WriteFile(filepath.Join(path, "other"), []byte("other"), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	repo.Put("doc", []byte("update"), "update doc", invalid)
+	res, _ = ioutil.ReadFile(filepath.Join(path, "other"))
+	if string(res) != "other" {
+		t.Error("Expected unrelated change to be kept")
+	}
+}
+
+func TestRepositoryRestoreStructureOnCommitFailure(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := archive.Put("source.lua", []byte("print(1)"), "add source"); err != nil {
+		t.Error(err)
+		return
+	}
+	rev, _ := repo.headRevision()
+	if err := archive.Put("source.lua", []byte("print(2)"), "update source"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	invalid := WithMetadata(map[string]string{"Bad Key": "value"})
+
+	assertRestored := func(step string) {
+		status, err := repo.Worktree.Status()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !status.IsClean() {
+			t.Error(step, "expected clean worktree, got:", status)
+		}
+		res, _ := archive.Fetch("source.lua")
+		if string(res) != "print(2)" {
+			t.Error(step, "expected restored document, got:", string(res))
+		}
+	}
+
+	_, err = CreateCollection(repo, "scripts", "add scripts", invalid)
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "scripts")); !os.IsNotExist(err) {
+		t.Error("Expected collection to be removed:", err)
+	}
+	assertRestored("create collection:")
+
+	_, err = programs.NextArchive("new program", invalid)
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	if _, err := os.Stat(filepath.Join(path, "programs", "2")); !os.IsNotExist(err) {
+		t.Error("Expected archive to be removed:", err)
+	}
+	if id := NextArchiveId(programs); id != 2 {
+		t.Error("Expected next archive id 2, got:", id)
+	}
+	assertRestored("next archive:")
+
+	if err := archive.Destroy("", invalid); err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	assertRestored("destroy archive:")
+
+	if err := programs.Destroy("", invalid); err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	assertRestored("destroy collection:")
+
+	if err := archive.Revert("source.lua", rev, "", invalid); err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	assertRestored("revert:")
+
+	if err := archive.RevertAll(rev, "", invalid); err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	assertRestored("revert all:")
+
+	// Three way merge
+	staging, err := repo.Branch("staging")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	staging.Put("programs/1/source.lua", []byte("print(3)"), "update source")
+	repo.Put("programs/1/other.lua", []byte("other"), "add other")
+
+	_, err = repo.Merge("staging", "master", "", invalid)
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	assertRestored("merge:")
+}
+
+func TestRepositoryRestoreFailureLogged(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	logger := &testLogger{}
+	opts := DefaultRepositoryOptions()
+	opts.Logger = logger
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	// The document can not be removed, the parent is a file
+	err = ioutil.WriteFile(filepath.Join(path, "blocker"), []byte("x"), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	invalid := WithMetadata(map[string]string{"Bad Key": "value"})
+	err = repo.commitOrRestore("add doc", []string{"blocker/doc"}, invalid)
+	if err != ErrInvalidTrailer {
+		t.Error("Expected commit error, got:", err)
+	}
+
+	entry := logger.find("could not restore documents after failed commit")
+	if entry == nil || entry.level != "ERROR" {
+		t.Error("Expected restore error to be logged, got:", entry)
+	}
+}
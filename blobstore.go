@@ -0,0 +1,492 @@
+package gitbase
+
+/*
+Large blob offloading:
+Documents larger than the blob threshold are stored in a
+content-addressed blob store outside of the repository.
+Only a small pointer file is committed:
+
+    version gitbase-blob/1
+    sha256 4d7a2146...
+    size 73400320
+
+Blobs are stored by their hash:
+
+    /path/to/store/4d/7a2146...
+
+Reading a document resolves pointers transparently. As
+every change of the content changes the pointer, the
+History of offloaded documents is not affected.
+
+Blobs no longer referenced by any commit or the
+worktree are removed by CollectBlobs.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBlobNotFound = errors.New("blob not found in blob store")
+)
+
+const (
+	blobPointerVersion = "version gitbase-blob/1"
+
+	// Pointer files are never larger than this
+	maxBlobPointerSize = 256
+)
+
+type blobPointer struct {
+	Hash string
+	Size int64
+}
+
+func (self *blobPointer) encode() []byte {
+	return []byte(fmt.Sprintf(
+		"%s\nsha256 %s\nsize %d\n",
+		blobPointerVersion, self.Hash, self.Size))
+}
+
+/*
+ Parse a pointer file, the document is not
+ a pointer if parsing fails.
+*/
+func parseBlobPointer(document []byte) (*blobPointer, bool) {
+	if len(document) > maxBlobPointerSize ||
+		!bytes.HasPrefix(document, []byte(blobPointerVersion+"\n")) {
+		return nil, false
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(document)), "\n")
+	if len(lines) != 3 {
+		return nil, false
+	}
+
+	hash := strings.TrimPrefix(lines[1], "sha256 ")
+	if len(hash) != sha256.Size*2 || !parseGitIsHash(hash) {
+		return nil, false
+	}
+
+	size, err := strconv.ParseInt(strings.TrimPrefix(lines[2], "size "), 10, 64)
+	if err != nil || !strings.HasPrefix(lines[2], "size ") {
+		return nil, false
+	}
+
+	return &blobPointer{Hash: hash, Size: size}, true
+}
+
+/*
+ Get the path of the blob store. If not configured,
+ the store is located next to the repository.
+*/
+func (self *Repository) blobStorePath() string {
+	if self.BlobStorePath != "" {
+		return self.BlobStorePath
+	}
+
+	root := self.rootRepository()
+	return filepath.Clean(root.BasePath) + ".blobs"
+}
+
+func (self *Repository) blobPath(hash string) string {
+	return filepath.Join(self.blobStorePath(), hash[:2], hash[2:])
+}
+
+/*
+ Check if a document of the size is offloaded
+*/
+func (self *Repository) offloads(size int64) bool {
+	return self.BlobThreshold > 0 && size > self.BlobThreshold
+}
+
+/*
+ Add content to the blob store
+*/
+func (self *Repository) storeBlob(reader io.Reader) (*blobPointer, error) {
+	storePath := self.blobStorePath()
+	if err := os.MkdirAll(storePath, 0755); err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile(storePath, "tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name()) // Fails after rename
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), reader)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pointer := &blobPointer{
+		Hash: hex.EncodeToString(hash.Sum(nil)),
+		Size: size,
+	}
+
+	// The content is already stored
+	path := self.blobPath(pointer.Hash)
+	if _, err := os.Stat(path); err == nil {
+		return pointer, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, err
+	}
+
+	return pointer, nil
+}
+
+/*
+ Replace large documents with a pointer
+*/
+func (self *Repository) offloadDocument(document []byte) ([]byte, error) {
+	if !self.offloads(int64(len(document))) {
+		return document, nil
+	}
+
+	pointer, err := self.storeBlob(bytes.NewReader(document))
+	if err != nil {
+		return nil, err
+	}
+
+	return pointer.encode(), nil
+}
+
+/*
+ Read a document from a reader, replace it with a pointer
+ if it is large. At most threshold bytes are buffered.
+*/
+func (self *Repository) offloadReader(reader io.Reader) ([]byte, error) {
+	head := make([]byte, self.BlobThreshold+1)
+	n, err := io.ReadFull(reader, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return head[:n], nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pointer, err := self.storeBlob(
+		io.MultiReader(bytes.NewReader(head), reader))
+	if err != nil {
+		return nil, err
+	}
+
+	return pointer.encode(), nil
+}
+
+/*
+ Replace a pointer with the content of the blob
+*/
+func (self *Repository) resolveDocument(document []byte) ([]byte, error) {
+	pointer, ok := parseBlobPointer(document)
+	if !ok {
+		return document, nil
+	}
+
+	content, err := ioutil.ReadFile(self.blobPath(pointer.Hash))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+
+	return content, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+/*
+ Replace a pointer stream with a stream of the blob
+*/
+func (self *Repository) resolveReader(
+	reader io.ReadCloser,
+) (io.ReadCloser, error) {
+	head := make([]byte, maxBlobPointerSize+1)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		reader.Close()
+		return nil, err
+	}
+	head = head[:n]
+
+	if err == nil {
+		// Too large for a pointer
+		return &readCloser{
+			Reader: io.MultiReader(bytes.NewReader(head), reader),
+			Closer: reader,
+		}, nil
+	}
+
+	pointer, ok := parseBlobPointer(head)
+	if !ok {
+		return &readCloser{
+			Reader: bytes.NewReader(head),
+			Closer: reader,
+		}, nil
+	}
+	reader.Close()
+
+	blob, err := os.Open(self.blobPath(pointer.Hash))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+
+	return blob, err
+}
+
+/*
+ Remove blobs, which are not referenced by any commit
+ reachable from a branch or tag, or by the worktree.
+ The number of removed blobs is returned.
+*/
+func (self *Repository) CollectBlobs() (int, error) {
+	return self.CollectBlobsContext(context.Background())
+}
+
+func (self *Repository) CollectBlobsContext(ctx context.Context) (int, error) {
+	root := self.rootRepository()
+	if err := root.lockContext(ctx); err != nil {
+		return 0, err
+	}
+	defer root.unlock()
+
+	referenced, err := root.referencedBlobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	storePath := self.blobStorePath()
+	err = filepath.Walk(storePath, func(
+		path string, info os.FileInfo, err error,
+	) error {
+		if os.IsNotExist(err) {
+			return nil // No blob store yet
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		// Leftovers of failed writes
+		if strings.HasPrefix(info.Name(), "tmp-") {
+			return os.Remove(path)
+		}
+
+		hash := filepath.Base(filepath.Dir(path)) + info.Name()
+		if referenced[hash] {
+			return nil
+		}
+
+		removed++
+		return os.Remove(path)
+	})
+
+	return removed, err
+}
+
+/*
+ Collect the hashes of all blobs referenced by pointers
+ in the history and in the worktrees. The caller must
+ hold the repository lock.
+*/
+func (self *Repository) referencedBlobs(
+	ctx context.Context,
+) (map[string]bool, error) {
+	referenced := map[string]bool{}
+
+	// Pointers in the history
+	commits, err := self.referencedCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	seenCommits := map[plumbing.Hash]bool{}
+	seenObjects := map[plumbing.Hash]bool{}
+	for len(commits) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		commit := commits[len(commits)-1]
+		commits = commits[:len(commits)-1]
+		if seenCommits[commit.Hash] {
+			continue
+		}
+		seenCommits[commit.Hash] = true
+
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+		err = collectTreePointers(tree, seenObjects, referenced)
+		if err != nil {
+			return nil, err
+		}
+
+		err = commit.Parents().ForEach(func(parent *object.Commit) error {
+			commits = append(commits, parent)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Pointers not yet committed, this includes
+	// the worktrees of branches in the git directory.
+	walkRoots := []string{
+		self.BasePath,
+		filepath.Join(self.gitDir, "gitbase", "branches"),
+	}
+	for _, walkRoot := range walkRoots {
+		err := filepath.Walk(walkRoot, func(
+			path string, info os.FileInfo, err error,
+		) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path == self.gitDir {
+				return filepath.SkipDir
+			}
+			if !info.Mode().IsRegular() || info.Size() > maxBlobPointerSize {
+				return nil
+			}
+
+			document, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if pointer, ok := parseBlobPointer(document); ok {
+				referenced[pointer.Hash] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return referenced, nil
+}
+
+/*
+ Get the commits of all branches and tags
+*/
+func (self *Repository) referencedCommits() ([]*object.Commit, error) {
+	commits := []*object.Commit{}
+
+	refs, err := self.gitRepo.References()
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		commit, err := self.gitRepo.CommitObject(ref.Hash())
+		if err == plumbing.ErrObjectNotFound {
+			// Annotated tag
+			tag, terr := self.gitRepo.TagObject(ref.Hash())
+			if terr != nil {
+				return nil
+			}
+			commit, err = tag.Commit()
+		}
+		if err != nil {
+			return err
+		}
+
+		commits = append(commits, commit)
+		return nil
+	})
+
+	return commits, err
+}
+
+/*
+ Collect the pointers in a tree and its subtrees
+*/
+func collectTreePointers(
+	tree *object.Tree,
+	seen map[plumbing.Hash]bool,
+	referenced map[string]bool,
+) error {
+	if seen[tree.Hash] {
+		return nil
+	}
+	seen[tree.Hash] = true
+
+	for _, entry := range tree.Entries {
+		if seen[entry.Hash] {
+			continue
+		}
+
+		if entry.Mode == filemode.Dir {
+			subtree, err := tree.Tree(entry.Name)
+			if err != nil {
+				return err
+			}
+			if err := collectTreePointers(subtree, seen, referenced); err != nil {
+				return err
+			}
+			continue
+		}
+		if !entry.Mode.IsFile() {
+			continue
+		}
+		seen[entry.Hash] = true
+
+		file, err := tree.TreeEntryFile(&entry)
+		if err != nil {
+			return err
+		}
+		if file.Size > maxBlobPointerSize {
+			continue
+		}
+
+		content, err := file.Contents()
+		if err != nil {
+			return err
+		}
+		if pointer, ok := parseBlobPointer([]byte(content)); ok {
+			referenced[pointer.Hash] = true
+		}
+	}
+
+	return nil
+}
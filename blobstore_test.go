@@ -0,0 +1,302 @@
+package gitbase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepositoryBlobOffloading(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	storePath, err := ioutil.TempDir("", "gitbase-test-blobs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(storePath)
+
+	opts := DefaultRepositoryOptions()
+	opts.BlobThreshold = 1024
+	opts.BlobStorePath = storePath
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	large := bytes.Repeat([]byte("x"), 4096)
+	if err := repo.Put("large", large, "add large"); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := repo.Put("small", []byte("small"), "add small"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Only the pointer is committed
+	committed, err := ioutil.ReadFile(filepath.Join(path, "large"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pointer, ok := parseBlobPointer(committed)
+	if !ok || pointer.Size != 4096 {
+		t.Error("Expected pointer, got:", string(committed))
+		return
+	}
+	if _, err := os.Stat(repo.blobPath(pointer.Hash)); err != nil {
+		t.Error("Expected blob in store:", err)
+	}
+
+	committed, _ = ioutil.ReadFile(filepath.Join(path, "small"))
+	if string(committed) != "small" {
+		t.Error("Expected small document not to be offloaded")
+	}
+
+	res, err := repo.Fetch("large")
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(res, large) {
+		t.Error("Expected resolved document, got", len(res), "bytes")
+	}
+
+	rev, _ := repo.headRevision()
+
+	// Replace with streamed content
+	updated := bytes.Repeat([]byte("y"), 2048)
+	err = repo.PutReader("large", bytes.NewReader(updated), "update large")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err = repo.FetchRevision("large", rev)
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(res, large) {
+		t.Error("Expected previous revision, got", len(res), "bytes")
+	}
+
+	reader, err := repo.OpenDocument("large")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, _ = ioutil.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(res, updated) {
+		t.Error("Expected updated document, got", len(res), "bytes")
+	}
+
+	history, err := repo.History("large")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(history) != 2 {
+		t.Error("Expected 2 commits, got:", len(history))
+	}
+
+	// Both blobs are referenced by the history
+	removed, err := repo.CollectBlobs()
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 0 {
+		t.Error("Expected no removed blobs, got:", removed)
+	}
+
+	orphan, err := repo.storeBlob(strings.NewReader("orphan"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	removed, err = repo.CollectBlobs()
+	if err != nil {
+		t.Error(err)
+	}
+	if removed != 1 {
+		t.Error("Expected 1 removed blob, got:", removed)
+	}
+	if _, err := os.Stat(repo.blobPath(orphan.Hash)); !os.IsNotExist(err) {
+		t.Error("Expected orphan blob to be removed")
+	}
+
+	// Missing blobs are reported
+	os.Remove(repo.blobPath(pointer.Hash))
+	if _, err := repo.FetchRevision("large", rev); err != ErrBlobNotFound {
+		t.Error("Expected ErrBlobNotFound, got:", err)
+	}
+}
+
+func TestRepositoryPointerLikeDocuments(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	pointer := &blobPointer{Hash: strings.Repeat("a", 64), Size: 42}
+	documents := [][]byte{
+		pointer.encode(),
+		[]byte(encryptionHeader + "2019-01\nciphertext"),
+		[]byte(escapeHeader + "escaped"),
+	}
+
+	for _, document := range documents {
+		if err := repo.Put("doc", document, "add doc"); err != nil {
+			t.Error(err)
+			return
+		}
+		res, err := repo.Fetch("doc")
+		if err != nil || !bytes.Equal(res, document) {
+			t.Error("Unexpected document:", string(res), err)
+		}
+
+		err = repo.PutReader("stream", bytes.NewReader(document), "add stream")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		reader, err := repo.OpenDocument("stream")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		res, _ = ioutil.ReadAll(reader)
+		reader.Close()
+		if !bytes.Equal(res, document) {
+			t.Error("Unexpected streamed document:", string(res))
+		}
+	}
+
+	// The escape line is not part of the diff
+	revs, err := repo.Revisions("doc")
+	if err != nil || len(revs) != 3 {
+		t.Error("Expected 3 revisions, got:", revs, err)
+		return
+	}
+	result, err := repo.Diff("doc", revs[1], revs[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Count(result.Unified, escapeHeader) != 1 ||
+		!strings.Contains(result.Unified, "-ciphertext\n") {
+		t.Error("Unexpected diff:", result.Unified)
+	}
+}
+
+func TestRepositoryBlobOffloadingDiff(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	storePath, err := ioutil.TempDir("", "gitbase-test-blobs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(storePath)
+
+	opts := DefaultRepositoryOptions()
+	opts.BlobThreshold = 16
+	opts.BlobStorePath = storePath
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	if _, err := repo.Use("docs"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	v1 := "first line\nsecond line\n"
+	v2 := "first line\nchanged line\n"
+	repo.Put("docs/large", []byte(v1), "add large")
+	repo.Put("docs/large", []byte(v2), "update large")
+
+	revs, err := repo.Revisions("docs/large")
+	if err != nil || len(revs) != 2 {
+		t.Error("Expected 2 revisions, got:", revs, err)
+		return
+	}
+
+	result, err := repo.Diff("docs/large", revs[1], revs[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, expected := range []string{"-second line\n", "+changed line\n"} {
+		if !strings.Contains(result.Unified, expected) {
+			t.Error("Expected unified diff to contain", expected,
+				"got:", result.Unified)
+		}
+	}
+	if strings.Contains(result.Unified, "sha256") {
+		t.Error("Expected content instead of pointers:", result.Unified)
+	}
+	if len(result.Hunks) != 1 || len(result.Hunks[0].Lines) != 3 {
+		t.Error("Unexpected hunks:", result.Hunks)
+	}
+
+	diffs, err := repo.DiffAll("docs", revs[1], revs[0])
+	if err != nil || len(diffs) != 1 {
+		t.Error("Expected 1 diff, got:", diffs, err)
+		return
+	}
+	if diffs[0].Unified != result.Unified {
+		t.Error("Unexpected diff:", diffs[0].Unified)
+	}
+
+	// Added documents
+	repo.Put("docs/new", []byte(v1), "add new")
+	head, _ := repo.headRevision()
+	result, err = repo.Diff("docs/new", revs[0], head)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !result.Added || !strings.Contains(result.Unified, "+second line\n") {
+		t.Error("Unexpected diff:", result.Unified)
+	}
+}
+
+func TestParseBlobPointer(t *testing.T) {
+	pointer := &blobPointer{
+		Hash: strings.Repeat("ab", 32),
+		Size: 42,
+	}
+
+	parsed, ok := parseBlobPointer(pointer.encode())
+	if !ok || *parsed != *pointer {
+		t.Error("Could not parse pointer:", parsed)
+	}
+
+	invalid := []string{
+		"",
+		"version gitbase-blob/1\n",
+		"version gitbase-blob/1\nsha256 abc\nsize 42\n",
+		"version gitbase-blob/1\nsha256 " + strings.Repeat("ab", 32) + "\nsize x\n",
+		"just a document",
+	}
+	for _, document := range invalid {
+		if _, ok := parseBlobPointer([]byte(document)); ok {
+			t.Error("Expected invalid pointer:", document)
+		}
+	}
+}
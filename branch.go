@@ -0,0 +1,533 @@
+package gitbase
+
+/*
+Branch views:
+A branch view is a repository, which reads and writes
+a named branch. It has its own worktree, index and HEAD,
+located in the git directory, and shares objects and
+references with the repository:
+
+  /path/to/repo/.git/gitbase/branches/<name>
+
+The main worktree is not touched by a branch view.
+
+Example:
+
+    staging, err := repo.Branch("staging")
+    programs, err := staging.Open("programs")
+    ...
+    conflicts, err := repo.Merge("staging", "master", "release")
+
+*/
+
+import (
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/index"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	ErrInvalidBranchName = errors.New("invalid branch name")
+	ErrBranchNotFound    = errors.New("branch not found")
+	ErrMergeConflict     = errors.New("merge conflict")
+)
+
+/*
+ The branch storage shares objects and references
+ with the repository, but keeps HEAD and the index
+ separate.
+*/
+type branchStorage struct {
+	*filesystem.Storage
+
+	head      *plumbing.Reference
+	indexPath string
+}
+
+func (self *branchStorage) SetReference(ref *plumbing.Reference) error {
+	if ref.Name() == plumbing.HEAD {
+		self.head = ref
+		return nil
+	}
+	return self.Storage.SetReference(ref)
+}
+
+func (self *branchStorage) CheckAndSetReference(
+	ref, old *plumbing.Reference,
+) error {
+	if ref.Name() == plumbing.HEAD {
+		self.head = ref
+		return nil
+	}
+	return self.Storage.CheckAndSetReference(ref, old)
+}
+
+func (self *branchStorage) Reference(
+	name plumbing.ReferenceName,
+) (*plumbing.Reference, error) {
+	if name == plumbing.HEAD {
+		return self.head, nil
+	}
+	return self.Storage.Reference(name)
+}
+
+func (self *branchStorage) Index() (*index.Index, error) {
+	idx := &index.Index{Version: 2}
+
+	f, err := os.Open(self.indexPath)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	err = index.NewDecoder(f).Decode(idx)
+	return idx, err
+}
+
+func (self *branchStorage) SetIndex(idx *index.Index) error {
+	tmpPath := self.indexPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = index.NewEncoder(f).Encode(idx)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, self.indexPath)
+}
+
+/*
+ Get the repository the view was derived from
+*/
+func (self *Repository) rootRepository() *Repository {
+	if self.root != nil {
+		return self.root
+	}
+	return self
+}
+
+/*
+ Get the name of the current branch
+*/
+func (self *Repository) CurrentBranch() (string, error) {
+	if self.branch != "" {
+		return self.branch, nil
+	}
+
+	head, err := self.gitRepo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	return head.Target().Short(), nil
+}
+
+/*
+ Get a view of a branch. If the branch does not
+ exist, it is created from the current HEAD.
+ If the branch is checked out in the main worktree,
+ the repository itself is returned. Uncommitted changes
+ in the worktree of the view are kept, if the branch was
+ changed since, a DirtyWorktreeError is returned.
+*/
+func (self *Repository) Branch(name string) (*Repository, error) {
+	return self.BranchContext(context.Background(), name)
+}
+
+func (self *Repository) BranchContext(
+	ctx context.Context, name string,
+) (*Repository, error) {
+	root := self.rootRepository()
+	if !isValidRefName(name) {
+		return nil, ErrInvalidBranchName
+	}
+
+	current, err := root.CurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+	if current == name {
+		return root, nil
+	}
+
+	storage, ok := root.gitRepo.Storer.(*filesystem.Storage)
+	if !ok {
+		return nil, errors.New("branches require filesystem storage")
+	}
+
+	if err := root.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer root.unlock()
+
+	// Create branch if required
+	refName := plumbing.NewBranchReferenceName(name)
+	_, err = root.gitRepo.Reference(refName, false)
+	if err == plumbing.ErrReferenceNotFound {
+		head, err := root.gitRepo.Head()
+		if err != nil {
+			return nil, err
+		}
+		ref := plumbing.NewHashReference(refName, head.Hash())
+		if err := root.gitRepo.Storer.SetReference(ref); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Open worktree of the branch
+	branchPath := filepath.Join(root.gitDir, "gitbase", "branches", name)
+	worktreePath := filepath.Join(branchPath, "worktree")
+	if err := os.MkdirAll(worktreePath, 0755); err != nil {
+		return nil, err
+	}
+
+	branchStorer := &branchStorage{
+		Storage:   storage,
+		head:      plumbing.NewSymbolicReference(plumbing.HEAD, refName),
+		indexPath: filepath.Join(branchPath, "index"),
+	}
+
+	gitRepo, err := git.Open(branchStorer, osfs.New(worktreePath))
+	if err != nil {
+		return nil, err
+	}
+
+	worktree, err := gitRepo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkoutBranchView(worktree, refName); err != nil {
+		return nil, err
+	}
+
+	view := &Repository{
+		BasePath:  worktreePath,
+		Worktree:  worktree,
+		Author:    root.Author,
+		Committer: root.Committer,
+		gitRepo:   gitRepo,
+		gitDir:    root.gitDir,
+
+		HistoryBackend: root.HistoryBackend,
+		FollowRenames:  root.FollowRenames,
+		LockTimeout:    root.LockTimeout,
+
+		SignKey: root.SignKey,
+		Keyring: root.Keyring,
+
+		BlobThreshold: root.BlobThreshold,
+		BlobStorePath: root.blobStorePath(),
+
+		Encryption: root.Encryption,
+
+		Logger: root.Logger,
+
+		root:   root,
+		branch: name,
+	}
+
+	return view, nil
+}
+
+/*
+ Bring the worktree of a branch view up to date with
+ the branch, the branch might have been changed by a
+ merge. Uncommitted changes are never overwritten: a dirty
+ worktree is used as it is, if its index is up to date
+ with the branch, otherwise a DirtyWorktreeError
+ is returned.
+*/
+func checkoutBranchView(
+	worktree *git.Worktree, refName plumbing.ReferenceName,
+) error {
+	status, err := worktree.Status()
+	if err != nil {
+		return err
+	}
+
+	dirty := []string{}
+	outdated := false
+	for path, fileStatus := range status {
+		if fileStatus.Worktree != git.Unmodified {
+			dirty = append(dirty, filepath.FromSlash(path))
+		}
+		// Untracked files are untracked in both
+		if fileStatus.Staging != git.Unmodified &&
+			fileStatus.Staging != git.Untracked {
+			outdated = true
+		}
+	}
+
+	if len(dirty) == 0 {
+		// Nothing to lose
+		return worktree.Checkout(&git.CheckoutOptions{
+			Branch: refName,
+			Force:  true,
+		})
+	}
+	if outdated {
+		sort.Strings(dirty)
+		return &DirtyWorktreeError{Paths: dirty}
+	}
+
+	return nil
+}
+
+/*
+ Merge a branch into another branch. If both branches
+ changed the same document, nothing is merged and the
+ conflicting keys are returned with ErrMergeConflict.
+ If the worktree of the target branch has uncommitted
+ changes, nothing is merged and a DirtyWorktreeError
+ is returned.
+*/
+func (self *Repository) Merge(
+	from, into string, reason string, opts ...WriteOption,
+) ([]string, error) {
+	return self.MergeContext(
+		context.Background(), from, into, reason, opts...)
+}
+
+func (self *Repository) MergeContext(
+	ctx context.Context,
+	from, into string,
+	reason string,
+	opts ...WriteOption,
+) ([]string, error) {
+	root := self.rootRepository()
+
+	// Fall back to default reason if required
+	if reason == "" {
+		reason = "merged " + from + " into " + into
+	}
+
+	// Resolve from first, the target branch is created
+	// if it does not exist.
+	fromRefName := plumbing.NewBranchReferenceName(from)
+	_, err := root.gitRepo.Reference(fromRefName, true)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, ErrBranchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := root.BranchContext(ctx, into)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := root.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer root.unlock()
+
+	fromRef, err := root.gitRepo.Reference(fromRefName, true)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, ErrBranchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Uncommitted changes would be lost by a fast forward,
+	// or committed with the merge.
+	dirty, err := target.dirtyPaths()
+	if err != nil {
+		return nil, err
+	}
+	if len(dirty) > 0 {
+		return nil, &DirtyWorktreeError{Paths: dirty}
+	}
+
+	fromCommit, err := root.gitRepo.CommitObject(fromRef.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	intoRef, err := target.gitRepo.Head()
+	if err != nil {
+		return nil, err
+	}
+	intoCommit, err := root.gitRepo.CommitObject(intoRef.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	// Already merged
+	merged, err := fromCommit.IsAncestor(intoCommit)
+	if err != nil {
+		return nil, err
+	}
+	if merged || fromCommit.Hash == intoCommit.Hash {
+		return nil, nil
+	}
+
+	// Fast forward
+	fastForward, err := intoCommit.IsAncestor(fromCommit)
+	if err != nil {
+		return nil, err
+	}
+	if fastForward {
+		err := target.Worktree.Reset(&git.ResetOptions{
+			Commit: fromCommit.Hash,
+			Mode:   git.HardReset,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, target.notifyChanges(intoCommit, fromCommit)
+	}
+
+	// Three way merge
+	changes, conflicts, err := mergeChanges(fromCommit, intoCommit)
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) > 0 {
+		return conflicts, ErrMergeConflict
+	}
+
+	keys := make([]string, 0, len(changes))
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		keys = append(keys, filepath.FromSlash(name))
+
+		if err := target.applyChange(change); err != nil {
+			return nil, err
+		}
+	}
+
+	opts = append(opts, withParents(intoCommit.Hash, fromCommit.Hash))
+	return nil, target.commitOrRestore(reason, keys, opts...)
+}
+
+/*
+ Get the changes of from since the merge base, and
+ the keys changed differently in both commits.
+*/
+func mergeChanges(
+	from, into *object.Commit,
+) (object.Changes, []string, error) {
+	baseTree := &object.Tree{}
+	bases, err := from.MergeBase(into)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(bases) > 0 {
+		baseTree, err = bases[0].Tree()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+	intoTree, err := into.Tree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fromChanges, err := object.DiffTree(baseTree, fromTree)
+	if err != nil {
+		return nil, nil, err
+	}
+	intoChanges, err := object.DiffTree(baseTree, intoTree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Resulting hash of every changed key in into
+	intoChanged := map[string]plumbing.Hash{}
+	for _, change := range intoChanges {
+		if change.To.Name == "" {
+			intoChanged[change.From.Name] = plumbing.ZeroHash
+			continue
+		}
+		intoChanged[change.To.Name] = change.To.TreeEntry.Hash
+	}
+
+	changes := object.Changes{}
+	conflicts := []string{}
+	for _, change := range fromChanges {
+		name := change.To.Name
+		hash := change.To.TreeEntry.Hash
+		if name == "" {
+			name = change.From.Name
+			hash = plumbing.ZeroHash
+		}
+
+		intoHash, ok := intoChanged[name]
+		if !ok {
+			changes = append(changes, change)
+			continue
+		}
+		if intoHash != hash {
+			conflicts = append(conflicts, name)
+		}
+	}
+
+	sort.Strings(conflicts)
+
+	return changes, conflicts, nil
+}
+
+/*
+ Apply a change to the worktree without committing.
+ The caller must hold the repository lock.
+*/
+func (self *Repository) applyChange(change *object.Change) error {
+	if change.To.Name == "" {
+		err := self.removeDocument(filepath.FromSlash(change.From.Name))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	_, file, err := change.Files()
+	if err != nil {
+		return err
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return err
+	}
+
+	key := filepath.FromSlash(change.To.Name)
+	path := filepath.Join(self.BasePath, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return self.writeStoredDocument(key, []byte(content))
+}
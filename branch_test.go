@@ -0,0 +1,345 @@
+package gitbase
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBranchView(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive.Put("source.lua", []byte("x = 1"), "add source")
+
+	staging, err := repo.Branch("staging")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Edit in staging
+	stagingPrograms, err := staging.Open("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	stagingArchive, err := stagingPrograms.Find(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = stagingArchive.Put("source.lua", []byte("x = 2"), "update source")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := stagingPrograms.NextArchive("staged program"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The main worktree is not affected
+	res, err := archive.Fetch("source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "x = 1" {
+		t.Error("Expected main branch to be unchanged, got:", string(res))
+	}
+	if _, err := programs.Find(2); err != ErrArchiveDoesNotExist {
+		t.Error("Expected archive 2 to not exist in main branch")
+	}
+
+	status, err := repo.Worktree.Status()
+	if err != nil {
+		t.Error(err)
+	}
+	if !status.IsClean() {
+		t.Error("Expected clean main worktree, got:", status)
+	}
+
+	// Reopening the branch view should yield the same state
+	staging, err = repo.Branch("staging")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err = staging.Fetch("programs/1/source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "x = 2" {
+		t.Error("Expected staging document, got:", string(res))
+	}
+
+	// The checked out branch is the repository itself
+	master, err := repo.Branch("master")
+	if err != nil {
+		t.Error(err)
+	}
+	if master != repo {
+		t.Error("Expected master to be the repository")
+	}
+
+	if _, err := repo.Branch("stag ing"); err != ErrInvalidBranchName {
+		t.Error("Expected ErrInvalidBranchName, got:", err)
+	}
+
+	// Fast forward merge
+	conflicts, err := repo.Merge("staging", "master", "release")
+	if err != nil {
+		t.Error(err, conflicts)
+		return
+	}
+
+	res, err = archive.Fetch("source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "x = 2" {
+		t.Error("Expected merged document, got:", string(res))
+	}
+	if _, err := programs.Find(2); err != nil {
+		t.Error("Expected archive 2 to exist after merge:", err)
+	}
+}
+
+func TestBranchMerge(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	repo.Put("a.doc", []byte("a"), "add a")
+	repo.Put("b.doc", []byte("b"), "add b")
+	repo.Put("c.doc", []byte("c"), "add c")
+
+	staging, err := repo.Branch("staging")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Diverge
+	staging.Put("a.doc", []byte("a staging"), "update a")
+	staging.Remove("c.doc", "remove c")
+	repo.Put("b.doc", []byte("b master"), "update b")
+
+	conflicts, err := repo.Merge("staging", "master", "")
+	if err != nil {
+		t.Error(err, conflicts)
+		return
+	}
+
+	expected := map[string]string{
+		"a.doc": "a staging",
+		"b.doc": "b master",
+	}
+	for key, content := range expected {
+		res, err := repo.Fetch(key)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(res) != content {
+			t.Error("Expected:", content, "got:", string(res))
+		}
+	}
+	if _, err := repo.Fetch("c.doc"); err == nil {
+		t.Error("Expected c.doc to be removed")
+	}
+
+	history, err := repo.History(".")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if history[0].Message != "merged staging into master" {
+		t.Error("Expected merge commit, got:", history[0].Message)
+	}
+
+	// Merging again does nothing
+	if _, err := repo.Merge("staging", "master", ""); err != nil {
+		t.Error(err)
+	}
+
+	// Conflicts
+	staging, err = repo.Branch("staging")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	staging.Put("a.doc", []byte("a staging 2"), "update a")
+	repo.Put("a.doc", []byte("a master"), "update a")
+
+	conflicts, err = repo.Merge("staging", "master", "")
+	if err != ErrMergeConflict {
+		t.Error("Expected ErrMergeConflict, got:", err)
+	}
+	if len(conflicts) != 1 || conflicts[0] != "a.doc" {
+		t.Error("Expected conflict in a.doc, got:", conflicts)
+	}
+
+	res, _ := repo.Fetch("a.doc")
+	if string(res) != "a master" {
+		t.Error("Expected master to be unchanged, got:", string(res))
+	}
+
+	if _, err := repo.Merge("unknown", "master", ""); err != ErrBranchNotFound {
+		t.Error("Expected ErrBranchNotFound, got:", err)
+	}
+
+	// The target branch is not created for an unknown branch
+	if _, err := repo.Merge("unknown", "typo", ""); err != ErrBranchNotFound {
+		t.Error("Expected ErrBranchNotFound, got:", err)
+	}
+	_, err = repo.gitRepo.Reference(plumbing.NewBranchReferenceName("typo"), false)
+	if err != plumbing.ErrReferenceNotFound {
+		t.Error("Expected target branch not to be created, got:", err)
+	}
+}
+
+func TestBranchMergeDirtyWorktree(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+	if err := repo.Put("a.doc", []byte("a"), "add a"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	staging, err := repo.Branch("staging")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := staging.Put("a.doc", []byte("a staging"), "update a"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Uncommitted changes in the main worktree
+	err = ioutil.WriteFile(filepath.Join(path, "a.doc"), []byte("local"), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = repo.Merge("staging", "master", "")
+	dirtyErr, ok := err.(*DirtyWorktreeError)
+	if !ok {
+		t.Error("Expected DirtyWorktreeError, got:", err)
+		return
+	}
+	if len(dirtyErr.Paths) != 1 || dirtyErr.Paths[0] != "a.doc" {
+		t.Error("Unexpected dirty paths:", dirtyErr.Paths)
+	}
+
+	res, _ := ioutil.ReadFile(filepath.Join(path, "a.doc"))
+	if string(res) != "local" {
+		t.Error("Expected uncommitted change to be kept, got:", string(res))
+	}
+}
+
+func TestBranchMergeDirtyBranchView(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+	if err := repo.Put("a.doc", []byte("a"), "add a"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	staging, err := repo.Branch("staging")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := repo.Put("b.doc", []byte("b"), "add b"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Uncommitted changes in the worktree of the branch view
+	local := filepath.Join(staging.BasePath, "a.doc")
+	if err := ioutil.WriteFile(local, []byte("local"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = repo.Merge("master", "staging", "")
+	dirtyErr, ok := err.(*DirtyWorktreeError)
+	if !ok {
+		t.Error("Expected DirtyWorktreeError, got:", err)
+		return
+	}
+	if len(dirtyErr.Paths) != 1 || dirtyErr.Paths[0] != "a.doc" {
+		t.Error("Unexpected dirty paths:", dirtyErr.Paths)
+	}
+
+	res, _ := ioutil.ReadFile(local)
+	if string(res) != "local" {
+		t.Error("Expected uncommitted change to be kept, got:", string(res))
+	}
+
+	// Opening the view again keeps the change
+	if _, err := repo.Branch("staging"); err != nil {
+		t.Error(err)
+	}
+	res, _ = ioutil.ReadFile(local)
+	if string(res) != "local" {
+		t.Error("Expected uncommitted change to be kept, got:", string(res))
+	}
+
+	// Without the change, the merge succeeds
+	if err := ioutil.WriteFile(local, []byte("a"), 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := repo.Merge("master", "staging", ""); err != nil {
+		t.Error(err)
+		return
+	}
+	staging, err = repo.Branch("staging")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, _ = staging.Fetch("b.doc")
+	if string(res) != "b" {
+		t.Error("Expected merged document, got:", string(res))
+	}
+}
@@ -1,11 +1,18 @@
 package gitbase
 
 import (
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"context"
 	"errors"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 /*
@@ -23,6 +30,20 @@ var (
 	ErrCollectionDoesNotExist = errors.New("collection does not exist")
 )
 
+/*
+ A collection summary provides some information about
+ the collection. All values are derived from the git
+ history and not from the worktree.
+*/
+type CollectionSummary struct {
+	Name string
+
+	ArchiveCount int
+
+	LastCommitId string
+	LastCommitAt time.Time
+}
+
 /*
  Calculate path of collection, derived from
  Name and the collection's base path
@@ -39,9 +60,13 @@ func (self *Collection) Path() string {
 /*
  Remove collection from repository
 */
-func (self *Collection) Destroy(reason string) error {
-	log.Println("Destroying collection:", self.Name)
+func (self *Collection) Destroy(reason string, opts ...WriteOption) error {
+	return self.DestroyContext(context.Background(), reason, opts...)
+}
 
+func (self *Collection) DestroyContext(
+	ctx context.Context, reason string, opts ...WriteOption,
+) error {
 	// Fall back to default reason if required
 	if reason == "" {
 		reason = "removed " + self.Name
@@ -57,24 +82,28 @@ func (self *Collection) Destroy(reason string) error {
 	defer fh.Close()
 
 	// Disallow write access to repository
-	self.Repository.Lock()
-	defer self.Repository.Unlock()
+	if err := self.Repository.lockContext(ctx); err != nil {
+		return err
+	}
+	defer self.Repository.unlock()
 
-	// Remove from filesystem
-	err = os.RemoveAll(path)
+	self.Repository.logger().Info("destroying collection",
+		"collection", self.Name)
+
+	// Documents to restore if the commit fails
+	keys, err := self.Repository.headKeys(self.Name)
 	if err != nil {
 		return err
 	}
 
-	// Stage this change to git repo
-	if err = self.Repository.StageChanges(); err != nil {
+	// Remove from filesystem
+	err = os.RemoveAll(path)
+	if err != nil {
 		return err
 	}
 
 	// Commit this change
-	err = self.Repository.Commit(reason)
-
-	return err
+	return self.Repository.commitOrRestore(reason, keys, opts...)
 }
 
 /*
@@ -84,40 +113,84 @@ func CreateCollection(
 	repo *Repository,
 	name string,
 	reason string,
+	opts ...WriteOption,
+) (*Collection, error) {
+	return CreateCollectionContext(
+		context.Background(), repo, name, reason, opts...)
+}
+
+func CreateCollectionContext(
+	ctx context.Context,
+	repo *Repository,
+	name string,
+	reason string,
+	opts ...WriteOption,
 ) (*Collection, error) {
+	if err := validateCollectionName(name); err != nil {
+		return nil, err
+	}
+
 	collection := &Collection{
 		Name:       name,
 		Repository: repo,
 	}
 	// Lock repository
-	repo.Lock()
-	defer repo.Unlock()
+	if err := repo.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer repo.unlock()
 
 	// Create filesystem path
-	path := collection.Path()
-	err := os.MkdirAll(path, 0755)
+	err := collection.createPath()
 	if err != nil {
 		return nil, err
 	}
 
+	// Insert into repository
+	gitkeep := filepath.Join(name, ".gitkeep")
+	err = repo.commitOrRestore(reason, []string{gitkeep}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+/*
+ Collection names must be valid names and must not
+ be hidden, as hidden directories are not listed.
+*/
+func validateCollectionName(name string) error {
+	if strings.HasPrefix(name, ".") {
+		return ErrInvalidKey
+	}
+	return ValidateName(name)
+}
+
+/*
+ Create the collection in the worktree without committing.
+ The caller must hold the repository lock.
+*/
+func (self *Collection) createPath() error {
+	path := self.Path()
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return err
+	}
+
 	// Add .gitkeep (for now, to have something to add
 	// to the repo). In future consider creating some
 	// metadata document.
 	gitkeep := filepath.Join(path, ".gitkeep")
 	err = ioutil.WriteFile(gitkeep, []byte{}, 0644)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Consider adding document storage support to
 	// collections.
 
-	// Insert into repository
-	if err = repo.CommitAll(reason); err != nil {
-		return nil, err
-	}
-
-	return collection, nil
+	return nil
 }
 
 /*
@@ -127,27 +200,133 @@ func OpenCollection(
 	repo *Repository,
 	name string,
 ) (*Collection, error) {
+	if err := validateCollectionName(name); err != nil {
+		return nil, err
+	}
+
 	collection := &Collection{
 		Name:       name,
 		Repository: repo,
 	}
-	path := collection.Path()
 
 	// Check if collection exists
-	fh, err := os.Open(path)
+	_, err := repo.readDir(name)
 	if os.IsNotExist(err) {
 		return nil, ErrCollectionDoesNotExist
 	}
-	defer fh.Close()
 
 	// Great, file exists, peachy.
 	return collection, nil
 }
 
+/*
+ List all collections in the repository
+*/
+func ListCollections(repo *Repository) ([]*Collection, error) {
+	collections := []*Collection{}
+
+	items, err := repo.readDir("")
+	if err != nil {
+		return collections, err
+	}
+
+	for _, item := range items {
+		if item.IsDir() == false {
+			continue
+		}
+
+		// This includes .git
+		if strings.HasPrefix(item.Name(), ".") {
+			continue
+		}
+
+		collection := &Collection{
+			Name:       item.Name(),
+			Repository: repo,
+		}
+
+		collections = append(collections, collection)
+	}
+
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+
+	return collections, nil
+}
+
+/*
+ Get a summary of the collection as of HEAD
+*/
+func (self *Collection) Summary() (*CollectionSummary, error) {
+	return self.SummaryContext(context.Background())
+}
+
+func (self *Collection) SummaryContext(
+	ctx context.Context,
+) (*CollectionSummary, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	summary := &CollectionSummary{
+		Name: self.Name,
+	}
+
+	tree, err := self.Repository.headTree()
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		// Nothing was committed yet
+		return summary, nil
+	}
+
+	collectionTree, err := tree.Tree(self.Name)
+	if err == object.ErrDirectoryNotFound {
+		// The collection is not (yet) part of the history
+		return summary, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range collectionTree.Entries {
+		if entry.Mode != filemode.Dir {
+			continue
+		}
+		if _, err := strconv.ParseUint(entry.Name, 10, 64); err != nil {
+			continue
+		}
+		summary.ArchiveCount++
+	}
+
+	// Get last change
+	history, err := self.Repository.HistoryContext(ctx, self.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) > 0 {
+		summary.LastCommitId = history[0].Id
+		summary.LastCommitAt = history[0].CreatedAt
+	}
+
+	return summary, nil
+}
+
 /*
  Get all archives
 */
 func (self *Collection) Archives() ([]*Archive, error) {
+	return self.ArchivesContext(context.Background())
+}
+
+func (self *Collection) ArchivesContext(
+	ctx context.Context,
+) ([]*Archive, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return ListArchives(self)
 }
 
@@ -155,12 +334,29 @@ func (self *Collection) Archives() ([]*Archive, error) {
  Find Archive
 */
 func (self *Collection) Find(id uint64) (*Archive, error) {
+	return self.FindContext(context.Background(), id)
+}
+
+func (self *Collection) FindContext(
+	ctx context.Context, id uint64,
+) (*Archive, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return OpenArchive(self, id)
 }
 
 /*
  Create a new Archive
 */
-func (self *Collection) NextArchive(reason string) (*Archive, error) {
-	return NextArchive(self, reason)
+func (self *Collection) NextArchive(
+	reason string, opts ...WriteOption,
+) (*Archive, error) {
+	return self.NextArchiveContext(context.Background(), reason, opts...)
+}
+
+func (self *Collection) NextArchiveContext(
+	ctx context.Context, reason string, opts ...WriteOption,
+) (*Archive, error) {
+	return NextArchiveContext(ctx, self, reason, opts...)
 }
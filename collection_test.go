@@ -2,6 +2,7 @@ package gitbase
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -48,3 +49,91 @@ func TestCollectionCreateDestroy(t *testing.T) {
 	}
 
 }
+
+func TestRepositoryCollections(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	// Create some collections
+	for _, name := range []string{"programs", "assets", "users"} {
+		if _, err := repo.Create(name, "new collection: "+name); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	collections, err := repo.Collections()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(collections) != 3 {
+		t.Error("Expected 3 collections, got:", len(collections))
+		return
+	}
+
+	// Collections should be sorted
+	expected := []string{"assets", "programs", "users"}
+	for i, collection := range collections {
+		if collection.Name != expected[i] {
+			t.Error("Expected:", expected[i], "got:", collection.Name)
+		}
+	}
+
+	// Add some archives and check the summary
+	programs := collections[1]
+	if _, err := programs.NextArchive("first program"); err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("second program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := archive.Put("source.lua", []byte("print(42)"), "add source"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	summary, err := programs.Summary()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if summary.ArchiveCount != 2 {
+		t.Error("Expected 2 archives, got:", summary.ArchiveCount)
+	}
+
+	history, err := repo.History(".")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if summary.LastCommitId != history[0].Id {
+		t.Error("Expected last commit:", history[0].Id,
+			"got:", summary.LastCommitId)
+	}
+	if summary.LastCommitAt.IsZero() {
+		t.Error("Expected last commit time to be set")
+	}
+
+	// Uncommitted archives should not be counted
+	os.MkdirAll(filepath.Join(programs.Path(), "23"), 0755)
+	summary, err = programs.Summary()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if summary.ArchiveCount != 2 {
+		t.Error("Expected 2 archives, got:", summary.ArchiveCount)
+	}
+}
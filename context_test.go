@@ -0,0 +1,115 @@
+package gitbase
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRepositoryContextCancel(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = repo.PutContext(ctx, "doc", []byte("data"), "put")
+	if err != context.Canceled {
+		t.Error("Expected context.Canceled, got:", err)
+	}
+	if _, err := repo.FetchContext(ctx, "doc"); err != context.Canceled {
+		t.Error("Expected context.Canceled, got:", err)
+	}
+
+	if err := repo.Put("doc", []byte("data"), "put"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, backend := range []HistoryBackend{HistoryNative, HistoryGitCLI} {
+		repo.HistoryBackend = backend
+		if _, err := repo.HistoryContext(ctx, "doc"); err == nil {
+			t.Error("Expected history to be cancelled")
+		}
+	}
+	repo.HistoryBackend = HistoryNative
+
+	// Waiting for the lock ends with the deadline
+	if err := repo.lock(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = repo.PutContext(ctx, "doc", []byte("blocked"), "put")
+	if err != context.DeadlineExceeded {
+		t.Error("Expected context.DeadlineExceeded, got:", err)
+	}
+
+	repo.unlock()
+
+	// The lock is released after the cancelled wait
+	if err := repo.Put("doc", []byte("update"), "put"); err != nil {
+		t.Error(err)
+	}
+
+	// The lock file is held by another process
+	if _, err := createLockFile(repo.lockPath()); err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(repo.lockPath())
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = repo.CreateContext(ctx, "programs", "create")
+	if err != context.DeadlineExceeded {
+		t.Error("Expected context.DeadlineExceeded, got:", err)
+	}
+}
+
+func TestRepositoryTransactionContext(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	if err := repo.Put("doc", []byte("before"), "put"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = repo.TransactionContext(ctx, "update", func(tx *Tx) error {
+		if err := tx.Put("doc", []byte("after")); err != nil {
+			return err
+		}
+		cancel()
+		return nil
+	})
+	if err != context.Canceled {
+		t.Error("Expected context.Canceled, got:", err)
+	}
+
+	res, err := repo.Fetch("doc")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "before" {
+		t.Error("Expected rolled back document, got:", string(res))
+	}
+}
@@ -0,0 +1,495 @@
+package gitbase
+
+/*
+Diff documents between two revisions.
+
+A diff is available as a list of hunks, similar to
+the hunks of a unified diff, and as unified diff text.
+*/
+
+import (
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/diff"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/utils/binary"
+	gitdiff "gopkg.in/src-d/go-git.v4/utils/diff"
+
+	"bytes"
+	"context"
+	"path"
+	"sort"
+	"strings"
+)
+
+const DiffContextLines = diff.DefaultContextLines
+
+type DiffOperation int
+
+const (
+	DiffEqual DiffOperation = iota
+	DiffAdd
+	DiffDelete
+)
+
+type DiffLine struct {
+	Operation DiffOperation
+	Text      string
+}
+
+/*
+ A hunk is a section of changed lines with some
+ surrounding context. Line numbers start at 1.
+*/
+type DiffHunk struct {
+	FromLine  int
+	FromCount int
+	ToLine    int
+	ToCount   int
+
+	Lines []DiffLine
+}
+
+/*
+ The diff of a document between two revisions
+*/
+type DocumentDiff struct {
+	Key     string
+	FromRev string
+	ToRev   string
+
+	Added   bool
+	Removed bool
+	Binary  bool
+
+	Hunks   []*DiffHunk
+	Unified string
+}
+
+/*
+ Check if the document differs between the revisions
+*/
+func (self *DocumentDiff) Changed() bool {
+	return self.Added || self.Removed || self.Unified != ""
+}
+
+/*
+ Diff a document between two revisions
+*/
+func (self *Repository) Diff(key, fromRev, toRev string) (*DocumentDiff, error) {
+	return self.DiffContext(context.Background(), key, fromRev, toRev)
+}
+
+func (self *Repository) DiffContext(
+	ctx context.Context, key, fromRev, toRev string,
+) (*DocumentDiff, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	key = path.Clean(key)
+
+	diffs, err := self.diffRevisions(ctx, fromRev, toRev, func(name string) bool {
+		return name == key
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(diffs) > 0 {
+		return diffs[0], nil
+	}
+
+	// The document is unchanged, but it should exist
+	if _, err := self.readRevision(key, toRev); err != nil {
+		return nil, err
+	}
+
+	return &DocumentDiff{
+		Key:     key,
+		FromRev: fromRev,
+		ToRev:   toRev,
+		Hunks:   []*DiffHunk{},
+	}, nil
+}
+
+/*
+ Diff all documents with a key prefix between two revisions.
+ Only changed documents are included.
+*/
+func (self *Repository) DiffAll(
+	prefix string, fromRev, toRev string,
+) ([]*DocumentDiff, error) {
+	return self.DiffAllContext(context.Background(), prefix, fromRev, toRev)
+}
+
+func (self *Repository) DiffAllContext(
+	ctx context.Context, prefix string, fromRev, toRev string,
+) ([]*DocumentDiff, error) {
+	if err := ValidateKey(prefix); err != nil {
+		return nil, err
+	}
+	prefix = path.Clean(prefix) + "/"
+
+	return self.diffRevisions(ctx, fromRev, toRev, func(name string) bool {
+		return strings.HasPrefix(name, prefix)
+	})
+}
+
+func (self *Repository) revisionTree(rev string) (*object.Tree, error) {
+	commit, err := self.resolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.Tree()
+}
+
+/*
+ Diff the trees of two revisions, include all
+ changes with a matching name.
+*/
+func (self *Repository) diffRevisions(
+	ctx context.Context,
+	fromRev, toRev string,
+	match func(name string) bool,
+) ([]*DocumentDiff, error) {
+	fromTree, err := self.revisionTree(fromRev)
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := self.revisionTree(toRev)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTreeContext(ctx, fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := []*DocumentDiff{}
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		if !match(name) {
+			continue
+		}
+
+		documentDiff, err := self.makeDocumentDiff(ctx, name, change)
+		if err != nil {
+			return nil, err
+		}
+		documentDiff.Key = name
+		documentDiff.FromRev = fromRev
+		documentDiff.ToRev = toRev
+
+		diffs = append(diffs, documentDiff)
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Key < diffs[j].Key
+	})
+
+	return diffs, nil
+}
+
+func (self *Repository) makeDocumentDiff(
+	ctx context.Context, key string, change *object.Change,
+) (*DocumentDiff, error) {
+	documentDiff := &DocumentDiff{
+		Added:   change.From.Name == "",
+		Removed: change.To.Name == "",
+		Hunks:   []*DiffHunk{},
+	}
+
+	patch, err := self.documentPatch(ctx, key, change)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := diff.NewUnifiedEncoder(buf, DiffContextLines)
+	if err := encoder.Encode(patch); err != nil {
+		return nil, err
+	}
+	documentDiff.Unified = buf.String()
+
+	for _, filePatch := range patch.FilePatches() {
+		if filePatch.IsBinary() {
+			documentDiff.Binary = true
+			continue
+		}
+		documentDiff.Hunks = append(
+			documentDiff.Hunks,
+			makeDiffHunks(filePatch.Chunks(), DiffContextLines)...)
+	}
+
+	return documentDiff, nil
+}
+
+/*
+ Make the patch of a change. Offloaded, encrypted and
+ escaped documents are compared by their content.
+*/
+func (self *Repository) documentPatch(
+	ctx context.Context, key string, change *object.Change,
+) (diff.Patch, error) {
+	from, to, err := change.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	fromStored, err := fileContent(from)
+	if err != nil {
+		return nil, err
+	}
+	toStored, err := fileContent(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasReservedHeader(fromStored) && !hasReservedHeader(toStored) {
+		return change.PatchContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	filePatch := &textFilePatch{}
+	if from != nil {
+		content, err := self.decodeDocument(key, fromStored)
+		if err != nil {
+			return nil, err
+		}
+		filePatch.from = makeTextFile(from, content)
+	}
+	if to != nil {
+		content, err := self.decodeDocument(key, toStored)
+		if err != nil {
+			return nil, err
+		}
+		filePatch.to = makeTextFile(to, content)
+	}
+
+	// Only the encoding changed, e.g. the encryption key
+	if filePatch.from != nil && filePatch.to != nil &&
+		filePatch.from.hash == filePatch.to.hash {
+		return &textPatch{}, nil
+	}
+
+	if err := filePatch.diff(); err != nil {
+		return nil, err
+	}
+
+	return &textPatch{filePatches: []diff.FilePatch{filePatch}}, nil
+}
+
+func fileContent(file *object.File) ([]byte, error) {
+	if file == nil {
+		return nil, nil
+	}
+	content, err := file.Contents()
+	return []byte(content), err
+}
+
+/*
+ A patch of decoded documents
+*/
+type textPatch struct {
+	filePatches []diff.FilePatch
+}
+
+func (self *textPatch) FilePatches() []diff.FilePatch {
+	return self.filePatches
+}
+
+func (self *textPatch) Message() string {
+	return ""
+}
+
+type textFile struct {
+	path    string
+	mode    filemode.FileMode
+	hash    plumbing.Hash
+	content []byte
+}
+
+func makeTextFile(file *object.File, content []byte) *textFile {
+	return &textFile{
+		path:    file.Name,
+		mode:    file.Mode,
+		hash:    plumbing.ComputeHash(plumbing.BlobObject, content),
+		content: content,
+	}
+}
+
+func (self *textFile) Hash() plumbing.Hash     { return self.hash }
+func (self *textFile) Mode() filemode.FileMode { return self.mode }
+func (self *textFile) Path() string            { return self.path }
+
+type textChunk struct {
+	content string
+	op      diff.Operation
+}
+
+func (self *textChunk) Content() string      { return self.content }
+func (self *textChunk) Type() diff.Operation { return self.op }
+
+type textFilePatch struct {
+	from   *textFile
+	to     *textFile
+	binary bool
+	chunks []diff.Chunk
+}
+
+/*
+ Compute the chunks of the patch like go-git does
+ for the contents of the git objects
+*/
+func (self *textFilePatch) diff() error {
+	var fromContent, toContent string
+	for _, file := range []*textFile{self.from, self.to} {
+		if file == nil {
+			continue
+		}
+		isBinary, err := binary.IsBinary(bytes.NewReader(file.content))
+		if err != nil {
+			return err
+		}
+		self.binary = self.binary || isBinary
+	}
+	if self.binary {
+		return nil
+	}
+
+	if self.from != nil {
+		fromContent = string(self.from.content)
+	}
+	if self.to != nil {
+		toContent = string(self.to.content)
+	}
+
+	for _, d := range gitdiff.Do(fromContent, toContent) {
+		op := diff.Equal
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = diff.Add
+		case diffmatchpatch.DiffDelete:
+			op = diff.Delete
+		}
+		self.chunks = append(self.chunks, &textChunk{d.Text, op})
+	}
+
+	return nil
+}
+
+func (self *textFilePatch) IsBinary() bool {
+	return self.binary
+}
+
+func (self *textFilePatch) Files() (diff.File, diff.File) {
+	// Missing files must be untyped nil
+	var from, to diff.File
+	if self.from != nil {
+		from = self.from
+	}
+	if self.to != nil {
+		to = self.to
+	}
+	return from, to
+}
+
+func (self *textFilePatch) Chunks() []diff.Chunk {
+	return self.chunks
+}
+
+/*
+ Group the lines of all chunks into hunks with
+ context lines around the changes.
+*/
+func makeDiffHunks(chunks []diff.Chunk, context int) []*DiffHunk {
+	lines := []DiffLine{}
+	for _, chunk := range chunks {
+		op := DiffEqual
+		switch chunk.Type() {
+		case diff.Add:
+			op = DiffAdd
+		case diff.Delete:
+			op = DiffDelete
+		}
+
+		for _, text := range splitLines(chunk.Content()) {
+			lines = append(lines, DiffLine{Operation: op, Text: text})
+		}
+	}
+
+	// Line numbers in the old and new document before each line
+	fromLines := make([]int, len(lines)+1)
+	toLines := make([]int, len(lines)+1)
+	for i, line := range lines {
+		fromLines[i+1] = fromLines[i]
+		toLines[i+1] = toLines[i]
+		if line.Operation != DiffAdd {
+			fromLines[i+1]++
+		}
+		if line.Operation != DiffDelete {
+			toLines[i+1]++
+		}
+	}
+
+	hunks := []*DiffHunk{}
+	start, end := -1, -1
+	flush := func() {
+		if start < 0 {
+			return
+		}
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		last := end + context
+		if last > len(lines) {
+			last = len(lines)
+		}
+
+		hunk := &DiffHunk{
+			FromLine:  fromLines[first] + 1,
+			FromCount: fromLines[last] - fromLines[first],
+			ToLine:    toLines[first] + 1,
+			ToCount:   toLines[last] - toLines[first],
+			Lines:     lines[first:last],
+		}
+		hunks = append(hunks, hunk)
+	}
+
+	for i, line := range lines {
+		if line.Operation == DiffEqual {
+			continue
+		}
+		if start >= 0 && i-end > 2*context {
+			flush()
+			start = -1
+		}
+		if start < 0 {
+			start = i
+		}
+		end = i + 1
+	}
+	flush()
+
+	return hunks
+}
+
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\n")
+	}
+	return lines
+}
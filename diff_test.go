@@ -0,0 +1,165 @@
+package gitbase
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDocumentDiff(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	collection, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := collection.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	v1 := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	v2 := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nL\nm\n"
+
+	archive.Put("source.lua", []byte(v1), "add source")
+	archive.Put("source.lua", []byte(v2), "update source")
+
+	revs, err := archive.Revisions("source.lua")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := archive.Diff("source.lua", revs[1], revs[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if result.Key != "programs/1/source.lua" {
+		t.Error("Unexpected key:", result.Key)
+	}
+	if result.Added || result.Removed {
+		t.Error("Expected a modification")
+	}
+
+	if len(result.Hunks) != 2 {
+		t.Error("Expected 2 hunks, got:", len(result.Hunks))
+		return
+	}
+
+	hunk := result.Hunks[0]
+	if hunk.FromLine != 1 || hunk.FromCount != 5 ||
+		hunk.ToLine != 1 || hunk.ToCount != 5 {
+		t.Error("Unexpected first hunk:", hunk)
+	}
+
+	hunk = result.Hunks[1]
+	if hunk.FromLine != 9 || hunk.FromCount != 4 ||
+		hunk.ToLine != 9 || hunk.ToCount != 5 {
+		t.Error("Unexpected second hunk:", hunk)
+	}
+
+	for _, expected := range []string{
+		"@@ -1,5 +1,5 @@", "-b\n", "+B\n", "-l\n", "+L\n", "+m\n",
+	} {
+		if !strings.Contains(result.Unified, expected) {
+			t.Error("Expected unified diff to contain", expected,
+				"got:", result.Unified)
+		}
+	}
+
+	// Unchanged
+	result, err = archive.Diff("source.lua", revs[0], revs[0])
+	if err != nil {
+		t.Error(err)
+	}
+	if result.Changed() {
+		t.Error("Expected no changes, got:", result.Unified)
+	}
+}
+
+func TestArchiveDiffAll(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	collection, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := collection.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	archive.Put("a.lua", []byte("a = 1\n"), "add a")
+	archive.Put("b.lua", []byte("b = 1\n"), "add b")
+
+	from, err := repo.headRevision()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	archive.Put("a.lua", []byte("a = 2\n"), "update a")
+	archive.Remove("b.lua", "remove b")
+	archive.Put("c.lua", []byte("c = 1\n"), "add c")
+
+	// Changes in other archives are not included
+	other, _ := collection.NextArchive("other program")
+	other.Put("a.lua", []byte("a = 3\n"), "add a")
+
+	diffs, err := archive.DiffAll(from, "HEAD")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(diffs) != 3 {
+		t.Error("Expected 3 changed documents, got:", len(diffs))
+		return
+	}
+
+	if diffs[0].Key != "programs/1/a.lua" || diffs[0].Added || diffs[0].Removed {
+		t.Error("Expected a.lua to be modified:", diffs[0])
+	}
+	if diffs[1].Key != "programs/1/b.lua" || !diffs[1].Removed {
+		t.Error("Expected b.lua to be removed:", diffs[1])
+	}
+	if diffs[2].Key != "programs/1/c.lua" || !diffs[2].Added {
+		t.Error("Expected c.lua to be added:", diffs[2])
+	}
+
+	// Single document diff of an added document
+	result, err := archive.Diff("c.lua", from, "HEAD")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !result.Added || len(result.Hunks) != 1 {
+		t.Error("Expected c.lua to be added:", result)
+	}
+
+	// Unknown document
+	_, err = archive.Diff("d.lua", from, "HEAD")
+	if err != ErrDocumentNotFound {
+		t.Error("Expected ErrDocumentNotFound, got:", err)
+	}
+}
@@ -0,0 +1,326 @@
+package gitbase
+
+/*
+Encryption at rest:
+Documents of a collection can be encrypted with AES-GCM.
+The keys are supplied by a KeyProvider per collection:
+
+    opts := DefaultRepositoryOptions()
+    opts.Encryption = map[string]KeyProvider{
+        "credentials": NewStaticKeyProvider("2019-01", keys),
+    }
+
+Encrypted documents start with a header containing the
+id of the key, followed by the nonce and the ciphertext:
+
+    gitbase-encrypted/1 <key id>\n<nonce><ciphertext>
+
+Documents are decrypted with the key referenced in the
+header, so keys can be rotated. Reencrypt rewrites all
+documents of a collection with the current key.
+
+Encrypted documents are read into memory for decryption,
+diffs of encrypted documents show the plaintext.
+*/
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	ErrInvalidKeyId      = errors.New("invalid encryption key id")
+	ErrKeyNotFound       = errors.New("encryption key not found")
+	ErrNoKeyProvider     = errors.New("no key provider for collection")
+	ErrInvalidCiphertext = errors.New("invalid encrypted document")
+	ErrDecryptionFailed  = errors.New("document could not be decrypted")
+)
+
+const encryptionHeader = "gitbase-encrypted/1 "
+
+/*
+ A key provider supplies the keys of a collection.
+ Keys must be 16, 24 or 32 bytes long, selecting
+ AES-128, AES-192 or AES-256.
+*/
+type KeyProvider interface {
+	// The key used for encrypting documents
+	CurrentKey() (id string, key []byte, err error)
+
+	// Get a key by id for decrypting documents.
+	// Return ErrKeyNotFound if the key is unknown.
+	Key(id string) ([]byte, error)
+}
+
+/*
+ A static key provider holds a fixed set of keys
+*/
+type StaticKeyProvider struct {
+	currentId string
+	keys      map[string][]byte
+}
+
+func NewStaticKeyProvider(
+	currentId string, keys map[string][]byte,
+) *StaticKeyProvider {
+	return &StaticKeyProvider{
+		currentId: currentId,
+		keys:      keys,
+	}
+}
+
+func (self *StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	key, err := self.Key(self.currentId)
+	return self.currentId, key, err
+}
+
+func (self *StaticKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := self.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+/*
+ Get the key provider of the collection a key belongs to
+*/
+func (self *Repository) keyProvider(key string) KeyProvider {
+	collection := strings.SplitN(key, "/", 2)[0]
+	return self.Encryption[collection]
+}
+
+func isEncrypted(document []byte) bool {
+	return bytes.HasPrefix(document, []byte(encryptionHeader))
+}
+
+/*
+ Get the key id from the header of an encrypted document
+*/
+func encryptedKeyId(document []byte) (string, []byte, error) {
+	end := bytes.IndexByte(document, '\n')
+	if !isEncrypted(document) || end < 0 {
+		return "", nil, ErrInvalidCiphertext
+	}
+
+	id := string(document[len(encryptionHeader):end])
+	return id, document[end+1:], nil
+}
+
+func makeGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+ Encrypt a document, if the collection is encrypted
+*/
+func (self *Repository) encryptDocument(
+	key string, document []byte,
+) ([]byte, error) {
+	provider := self.keyProvider(key)
+	if provider == nil {
+		return document, nil
+	}
+
+	id, encryptionKey, err := provider.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if id == "" || strings.ContainsAny(id, " \r\n") {
+		return nil, ErrInvalidKeyId
+	}
+
+	gcm, err := makeGCM(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	// The key id is authenticated with the document
+	header := []byte(encryptionHeader + id + "\n")
+
+	encrypted := make([]byte, 0, len(header)+len(nonce)+
+		len(document)+gcm.Overhead())
+	encrypted = append(encrypted, header...)
+	encrypted = append(encrypted, nonce...)
+	encrypted = gcm.Seal(encrypted, nonce, document, header)
+
+	return encrypted, nil
+}
+
+/*
+ Decrypt a document, unencrypted documents are
+ returned as they are.
+*/
+func (self *Repository) decryptDocument(
+	key string, document []byte,
+) ([]byte, error) {
+	if !isEncrypted(document) {
+		return document, nil
+	}
+
+	provider := self.keyProvider(key)
+	if provider == nil {
+		return nil, ErrNoKeyProvider
+	}
+
+	id, payload, err := encryptedKeyId(document)
+	if err != nil {
+		return nil, err
+	}
+
+	decryptionKey, err := provider.Key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := makeGCM(decryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < gcm.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	header := document[:len(document)-len(payload)]
+	nonce := payload[:gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, payload[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return plaintext, nil
+}
+
+/*
+ Decrypt a stream. Encrypted documents are read
+ into memory to be authenticated.
+*/
+func (self *Repository) decryptReader(
+	key string, reader io.ReadCloser,
+) (io.ReadCloser, error) {
+	head := make([]byte, len(encryptionHeader))
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		reader.Close()
+		return nil, err
+	}
+	head = head[:n]
+
+	stream := io.MultiReader(bytes.NewReader(head), reader)
+	if !isEncrypted(head) {
+		return &readCloser{Reader: stream, Closer: reader}, nil
+	}
+	defer reader.Close()
+
+	document, err := ioutil.ReadAll(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err = self.decryptDocument(key, document)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(document)), nil
+}
+
+/*
+ Rewrite all documents of the collection, which are not
+ encrypted with the current key. The number of rewritten
+ documents is returned. All changes are committed at once.
+*/
+func (self *Collection) Reencrypt(
+	reason string, opts ...WriteOption,
+) (int, error) {
+	return self.ReencryptContext(context.Background(), reason, opts...)
+}
+
+func (self *Collection) ReencryptContext(
+	ctx context.Context, reason string, opts ...WriteOption,
+) (int, error) {
+	repo := self.Repository
+	provider := repo.keyProvider(self.Name)
+	if provider == nil {
+		return 0, ErrNoKeyProvider
+	}
+
+	currentId, _, err := provider.CurrentKey()
+	if err != nil {
+		return 0, err
+	}
+
+	archives, err := self.ArchivesContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Fall back to default reason if required
+	if reason == "" {
+		reason = "reencrypted " + self.Name + " with key " + currentId
+	}
+
+	count := 0
+	err = repo.TransactionContext(ctx, reason, func(tx *Tx) error {
+		for _, archive := range archives {
+			documents, err := archive.DocumentsContext(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, name := range documents {
+				key := archive.Key(name)
+				stored, err := repo.readStoredDocument(key)
+				if err != nil {
+					return err
+				}
+
+				// Offloaded documents are stored as pointers
+				resolved, err := repo.resolveDocument(stored)
+				if err != nil {
+					return err
+				}
+
+				id, _, err := encryptedKeyId(resolved)
+				if err == nil && id == currentId {
+					continue
+				}
+
+				document, err := repo.decodeDocument(key, resolved)
+				if err != nil {
+					return err
+				}
+
+				// Pre-write hooks were applied when the
+				// document was written.
+				tx.touch(key)
+				if err := repo.writeDocument(key, document); err != nil {
+					return err
+				}
+				count++
+			}
+		}
+		return nil
+	}, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,211 @@
+package gitbase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCollectionEncryption(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	keys := map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}
+	provider := NewStaticKeyProvider("k1", keys)
+
+	opts := DefaultRepositoryOptions()
+	opts.Encryption = map[string]KeyProvider{"secrets": provider}
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	secrets, err := repo.Use("secrets")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := secrets.NextArchive("new secrets")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	plaintext := []byte("password=hunter2")
+	if err := archive.Put("credentials", plaintext, "add credentials"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The document is stored encrypted
+	key := archive.Key("credentials")
+	stored, err := ioutil.ReadFile(filepath.Join(path, key))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	id, _, err := encryptedKeyId(stored)
+	if err != nil || id != "k1" {
+		t.Error("Expected document encrypted with k1, got:", id, err)
+	}
+	if bytes.Contains(stored, plaintext) {
+		t.Error("Expected no plaintext in stored document")
+	}
+
+	res, err := archive.Fetch("credentials")
+	if err != nil || !bytes.Equal(res, plaintext) {
+		t.Error("Unexpected document:", string(res), err)
+	}
+
+	rev, _ := repo.headRevision()
+
+	// Other collections are not encrypted
+	if err := repo.Put("public", []byte("hello"), "add public"); err != nil {
+		t.Error(err)
+		return
+	}
+	stored, _ = ioutil.ReadFile(filepath.Join(path, "public"))
+	if string(stored) != "hello" {
+		t.Error("Expected plaintext document, got:", string(stored))
+	}
+
+	// Rotate the key and reencrypt
+	provider.currentId = "k2"
+	count, err := secrets.Reencrypt("")
+	if err != nil || count != 1 {
+		t.Error("Expected 1 reencrypted document, got:", count, err)
+	}
+	stored, _ = ioutil.ReadFile(filepath.Join(path, key))
+	if id, _, _ := encryptedKeyId(stored); id != "k2" {
+		t.Error("Expected document encrypted with k2, got:", id)
+	}
+
+	// Nothing left to do
+	count, err = secrets.Reencrypt("")
+	if err != nil || count != 0 {
+		t.Error("Expected 0 reencrypted documents, got:", count, err)
+	}
+
+	// Old revisions are decrypted with the old key
+	res, err = archive.FetchRevision("credentials", rev)
+	if err != nil || !bytes.Equal(res, plaintext) {
+		t.Error("Unexpected document:", string(res), err)
+	}
+
+	reader, err := archive.Open("credentials")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err = ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || !bytes.Equal(res, plaintext) {
+		t.Error("Unexpected document:", string(res), err)
+	}
+
+	// Reverting restores the stored document as it is
+	if err := archive.Revert("credentials", rev, ""); err != nil {
+		t.Error(err)
+		return
+	}
+	stored, _ = ioutil.ReadFile(filepath.Join(path, key))
+	if id, _, _ := encryptedKeyId(stored); id != "k1" {
+		t.Error("Expected reverted document encrypted with k1, got:", id)
+	}
+
+	// Removed keys can not be used for decryption
+	delete(keys, "k1")
+	if _, err := archive.Fetch("credentials"); err != ErrKeyNotFound {
+		t.Error("Expected ErrKeyNotFound, got:", err)
+	}
+
+	// Tampered documents are rejected
+	keys["k1"] = bytes.Repeat([]byte{1}, 32)
+	stored[len(stored)-1] ^= 0xff
+	if err := ioutil.WriteFile(filepath.Join(path, key), stored, 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := archive.Fetch("credentials"); err != ErrDecryptionFailed {
+		t.Error("Expected ErrDecryptionFailed, got:", err)
+	}
+}
+
+func TestCollectionReencryptOffloaded(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	storePath, err := ioutil.TempDir("", "gitbase-test-blobs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(storePath)
+
+	keys := map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}
+	provider := NewStaticKeyProvider("k1", keys)
+
+	opts := DefaultRepositoryOptions()
+	opts.Encryption = map[string]KeyProvider{"secrets": provider}
+	opts.BlobThreshold = 1024
+	opts.BlobStorePath = storePath
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	secrets, err := repo.Use("secrets")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := secrets.NextArchive("new secrets")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	large := bytes.Repeat([]byte("x"), 4096)
+	if err := archive.Put("large", large, "add large"); err != nil {
+		t.Error(err)
+		return
+	}
+	stored, _ := ioutil.ReadFile(filepath.Join(path, archive.Key("large")))
+	if _, ok := parseBlobPointer(stored); !ok {
+		t.Error("Expected pointer, got:", string(stored))
+		return
+	}
+
+	provider.currentId = "k2"
+	count, err := secrets.Reencrypt("")
+	if err != nil || count != 1 {
+		t.Error("Expected 1 reencrypted document, got:", count, err)
+	}
+
+	// The offloaded document is encrypted with the current key
+	rev, _ := repo.headRevision()
+	count, err = secrets.Reencrypt("")
+	if err != nil || count != 0 {
+		t.Error("Expected 0 reencrypted documents, got:", count, err)
+	}
+	if head, _ := repo.headRevision(); head != rev {
+		t.Error("Expected no new commit, got:", head)
+	}
+
+	res, err := archive.Fetch("large")
+	if err != nil || !bytes.Equal(res, large) {
+		t.Error("Unexpected document:", len(res), err)
+	}
+}
@@ -0,0 +1,120 @@
+package gitbase
+
+/*
+Escaping documents:
+Pointers to offloaded blobs and encrypted documents are
+recognized by their header. A plain document starting
+with one of these headers is stored with an additional
+escape line, so it is never mistaken for a pointer or
+ciphertext:
+
+    gitbase-escaped/1\n<document>
+
+The escape line is removed when the document is read.
+*/
+
+import (
+	"bytes"
+	"io"
+)
+
+const escapeHeader = "gitbase-escaped/1\n"
+
+// Documents starting with one of these headers are escaped
+var reservedHeaders = []string{
+	blobPointerVersion + "\n",
+	encryptionHeader,
+	escapeHeader,
+}
+
+func maxReservedHeaderSize() int {
+	size := 0
+	for _, header := range reservedHeaders {
+		if len(header) > size {
+			size = len(header)
+		}
+	}
+	return size
+}
+
+/*
+ Check if a document starts with a reserved header,
+ plain documents starting with one must be escaped
+*/
+func hasReservedHeader(document []byte) bool {
+	for _, header := range reservedHeaders {
+		if bytes.HasPrefix(document, []byte(header)) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ Escape a plain document if it starts with a reserved header
+*/
+func escapeDocument(document []byte) []byte {
+	if !hasReservedHeader(document) {
+		return document
+	}
+
+	escaped := make([]byte, 0, len(escapeHeader)+len(document))
+	escaped = append(escaped, escapeHeader...)
+	return append(escaped, document...)
+}
+
+/*
+ Escape a plain document stream if it starts with
+ a reserved header
+*/
+func escapeReader(reader io.Reader) (io.Reader, error) {
+	head := make([]byte, maxReservedHeaderSize())
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	stream := io.MultiReader(bytes.NewReader(head), reader)
+	if !hasReservedHeader(head) {
+		return stream, nil
+	}
+
+	return io.MultiReader(bytes.NewReader([]byte(escapeHeader)), stream), nil
+}
+
+/*
+ Remove the escape line, the second return value
+ reports if the document was escaped.
+*/
+func unescapeDocument(document []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(document, []byte(escapeHeader)) {
+		return document, false
+	}
+	return document[len(escapeHeader):], true
+}
+
+/*
+ Remove the escape line from a stream, the second return
+ value reports if the document was escaped.
+*/
+func unescapeReader(
+	reader io.ReadCloser,
+) (io.ReadCloser, bool, error) {
+	head := make([]byte, len(escapeHeader))
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		reader.Close()
+		return nil, false, err
+	}
+	head = head[:n]
+
+	if bytes.Equal(head, []byte(escapeHeader)) {
+		return reader, true, nil
+	}
+
+	return &readCloser{
+		Reader: io.MultiReader(bytes.NewReader(head), reader),
+		Closer: reader,
+	}, false, nil
+}
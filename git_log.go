@@ -19,8 +19,8 @@ import (
 
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
-	"log"
 	"strconv"
 	"strings"
 	"time"
@@ -40,19 +40,32 @@ type Commit struct {
 
 	Message string
 
+	// The message split into subject, body and trailers
+	Subject  string
+	Body     string
+	Trailers map[string]string
+
 	CreatedAt time.Time
+
+	// Signature verification, only set by History
+	Verification VerificationStatus
+	SignerKeyId  string
 }
 
-func execGitLogFollow(repoPath string, path string) ([]byte, error) {
-	cmd := exec.Command(
-		"git", "-C", repoPath, "log", "--pretty=raw", "--follow", path,
+func execGitLogFollow(
+	ctx context.Context, repoPath string, path string,
+) ([]byte, error) {
+	cmd := exec.CommandContext(ctx,
+		"git", "-C", repoPath, "log", "--pretty=raw", "--follow", "--", path,
 	)
 	return cmd.Output()
 }
 
-func execGitLog(repoPath string, path string) ([]byte, error) {
-	cmd := exec.Command(
-		"git", "-C", repoPath, "log", "--pretty=raw", path,
+func execGitLog(
+	ctx context.Context, repoPath string, path string,
+) ([]byte, error) {
+	cmd := exec.CommandContext(ctx,
+		"git", "-C", repoPath, "log", "--pretty=raw", "--", path,
 	)
 	return cmd.Output()
 }
@@ -94,7 +107,7 @@ func parseGitIsHash(hash string) bool {
 /*
 Parse command output, interpret error
 */
-func parseGitLog(data []byte, err error) ([]*Commit, error) {
+func parseGitLog(logger Logger, data []byte, err error) ([]*Commit, error) {
 	commits := []*Commit{}
 	if err != nil {
 		return commits, err
@@ -112,11 +125,18 @@ func parseGitLog(data []byte, err error) ([]*Commit, error) {
 	scanner := bufio.NewScanner(bytes.NewReader(data))
 	for scanner.Scan() {
 		if err = scanner.Err(); err != nil {
-			log.Println(err)
+			logger.Error("could not read git log", "error", err)
 			continue
 		}
 
 		line := scanner.Text()
+
+		// Continuation of a multiline header,
+		// e.g. the signature of a signed commit
+		if state == stateHeader && strings.HasPrefix(line, " ") {
+			continue
+		}
+
 		line = strings.TrimSpace(line)
 
 		if parseGitIsHeaderStart(line) {
@@ -126,6 +146,7 @@ func parseGitLog(data []byte, err error) ([]*Commit, error) {
 			} else {
 				// Next commit
 				commit.Message = strings.TrimSpace(commit.Message)
+				commit.parseMessage()
 				commits = append(commits, commit)
 				commit = &Commit{}
 			}
@@ -144,7 +165,8 @@ func parseGitLog(data []byte, err error) ([]*Commit, error) {
 				// author line
 				createdAt, err := gitParseTimestampFromAuthor(commit.Author)
 				if err != nil {
-					log.Println(err)
+					logger.Warn("invalid author timestamp in git log",
+						"commit_id", commit.Id, "error", err)
 					continue
 				}
 				commit.CreatedAt = createdAt
@@ -164,8 +186,12 @@ func parseGitLog(data []byte, err error) ([]*Commit, error) {
 			case "committer":
 				commit.Committer = tokens[1]
 				break
+			case "gpgsig":
+				// Signatures are verified with the commit object
+				break
 			default:
-				log.Println("Unknown token:", tokens[0])
+				logger.Warn("unknown token in git log",
+					"commit_id", commit.Id, "token", tokens[0])
 			}
 		} else if state == stateMessage {
 			commit.Message += line + "\n"
@@ -175,6 +201,7 @@ func parseGitLog(data []byte, err error) ([]*Commit, error) {
 	// Add last commit
 	if commit != nil {
 		commit.Message = strings.TrimSpace(commit.Message)
+		commit.parseMessage()
 		commits = append(commits, commit)
 	}
 
@@ -212,5 +239,39 @@ func gitParseTimestampFromAuthor(line string) (time.Time, error) {
 }
 
 func GitHistory(basePath, path string) ([]*Commit, error) {
-	return parseGitLog(execGitLog(basePath, path))
+	return GitHistoryContext(context.Background(), basePath, path)
+}
+
+func GitHistoryFollow(basePath, path string) ([]*Commit, error) {
+	return GitHistoryFollowContext(context.Background(), basePath, path)
+}
+
+/*
+ The git process is killed when the context is done
+*/
+func GitHistoryContext(
+	ctx context.Context, basePath, path string,
+) ([]*Commit, error) {
+	return gitHistory(ctx, DefaultLogger, basePath, path, false)
+}
+
+func GitHistoryFollowContext(
+	ctx context.Context, basePath, path string,
+) ([]*Commit, error) {
+	return gitHistory(ctx, DefaultLogger, basePath, path, true)
+}
+
+func gitHistory(
+	ctx context.Context,
+	logger Logger,
+	basePath, path string,
+	follow bool,
+) ([]*Commit, error) {
+	if follow {
+		data, err := execGitLogFollow(ctx, basePath, path)
+		return parseGitLog(logger, data, err)
+	}
+
+	data, err := execGitLog(ctx, basePath, path)
+	return parseGitLog(logger, data, err)
 }
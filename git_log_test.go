@@ -1,6 +1,7 @@
 package gitbase
 
 import (
+	"context"
 	"os"
 	"testing"
 )
@@ -29,7 +30,8 @@ func TestExecGitLog(t *testing.T) {
 	}
 
 	// Exec git log
-	commits, err := parseGitLog(execGitLogFollow(repo.BasePath, "."))
+	data, err := execGitLogFollow(context.Background(), repo.BasePath, ".")
+	commits, err := parseGitLog(DefaultLogger, data, err)
 	if err != nil {
 		t.Error(err)
 	}
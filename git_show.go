@@ -3,18 +3,24 @@ package gitbase
 import (
 	"os/exec"
 
+	"context"
 	"errors"
 	"fmt"
 )
 
 var (
 	ErrInvalidRevisionHash = errors.New("invalid revision hash")
-	ErrRevsionNotFound     = errors.New("revision not found in repository")
+	ErrRevisionNotFound    = errors.New("revision not found in repository")
+
+	// Deprecated: misspelled, use ErrRevisionNotFound
+	ErrRevsionNotFound = ErrRevisionNotFound
 )
 
-func execGitShow(repoPath, path, revision string) ([]byte, error) {
+func execGitShow(
+	ctx context.Context, repoPath, path, revision string,
+) ([]byte, error) {
 	show := fmt.Sprintf("%s:%s", revision, path)
-	cmd := exec.Command(
+	cmd := exec.CommandContext(ctx,
 		"git", "-C", repoPath, "show", show,
 	)
 
@@ -23,8 +29,15 @@ func execGitShow(repoPath, path, revision string) ([]byte, error) {
 
 // Export
 func GitShow(repoPath, path, revision string) ([]byte, error) {
+	return GitShowContext(context.Background(), repoPath, path, revision)
+}
+
+// The git process is killed when the context is done
+func GitShowContext(
+	ctx context.Context, repoPath, path, revision string,
+) ([]byte, error) {
 	if !parseGitIsHash(revision) {
 		return nil, ErrInvalidRevisionHash
 	}
-	return execGitShow(repoPath, path, revision)
+	return execGitShow(ctx, repoPath, path, revision)
 }
@@ -1,6 +1,7 @@
 package gitbase
 
 import (
+	"context"
 	"os"
 	"testing"
 )
@@ -36,7 +37,7 @@ func TestExecGitShow(t *testing.T) {
 	}
 
 	// Test git show
-	result, err := execGitShow(repo.BasePath, "test.doc", revisions[0])
+	result, err := execGitShow(context.Background(), repo.BasePath, "test.doc", revisions[0])
 	if err != nil {
 		t.Error(err)
 	}
@@ -45,7 +46,7 @@ func TestExecGitShow(t *testing.T) {
 		t.Error("Expected: 'bar', got:", string(result))
 	}
 
-	result, err = execGitShow(repo.BasePath, "test.doc", revisions[1])
+	result, err = execGitShow(context.Background(), repo.BasePath, "test.doc", revisions[1])
 	if err != nil {
 		t.Error(err)
 	}
@@ -54,12 +55,12 @@ func TestExecGitShow(t *testing.T) {
 	}
 
 	// This should yield an error
-	result, err = execGitShow(repo.BasePath, "test.doc", "d3adb33f")
+	result, err = execGitShow(context.Background(), repo.BasePath, "test.doc", "d3adb33f")
 	if err == nil {
 		t.Error(err)
 	}
 
-	_, err = execGitShow(repo.BasePath, "test.dog", revisions[0])
+	_, err = execGitShow(context.Background(), repo.BasePath, "test.dog", revisions[0])
 	if err == nil {
 		t.Error("Expected error with unkonwn file")
 	}
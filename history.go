@@ -0,0 +1,231 @@
+package gitbase
+
+/*
+History supplemental:
+Walk the commit history using go-git, without
+depending on the git cli.
+
+This implements the equivalent of:
+
+  git log <path>
+
+and with rename detection:
+
+  git log --follow <path>
+
+Renames are detected when a document was added in
+a commit and a document with identical content was
+removed in the same commit.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ The history backend selects the implementation
+ used by Repository.History.
+*/
+type HistoryBackend int
+
+const (
+	HistoryNative HistoryBackend = iota
+	HistoryGitCLI
+)
+
+/*
+ Get the commit history of a path using go-git
+*/
+func (self *Repository) nativeHistory(
+	ctx context.Context,
+	key string,
+	follow bool,
+) ([]*Commit, error) {
+	commits := []*Commit{}
+	path := filepath.ToSlash(filepath.Clean(key))
+
+	head, err := self.headCommit()
+	if err != nil {
+		return commits, err
+	}
+	if head == nil {
+		// Nothing was committed yet
+		return commits, nil
+	}
+
+	iter, err := self.gitRepo.Log(&git.LogOptions{
+		From:  head.Hash,
+		Order: git.LogOrderCommitterTime,
+	})
+	if err != nil {
+		return commits, err
+	}
+	defer iter.Close()
+
+	err = iter.ForEach(func(commit *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hash, err := commitPathHash(commit, path)
+		if err != nil {
+			return err
+		}
+
+		parents := []*object.Commit{}
+		err = commit.Parents().ForEach(func(parent *object.Commit) error {
+			parents = append(parents, parent)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Like git log, a commit is only part of the history
+		// if the path differs from all of its parents.
+		changed := len(parents) > 0 || !hash.IsZero()
+		for _, parent := range parents {
+			parentHash, err := commitPathHash(parent, path)
+			if err != nil {
+				return err
+			}
+			if parentHash == hash {
+				changed = false
+				break
+			}
+		}
+
+		if !changed {
+			return nil
+		}
+
+		commits = append(commits, makeCommit(commit))
+
+		// Check if the document was renamed in this commit
+		if follow && len(parents) > 0 && !hash.IsZero() {
+			renamed, err := findRenameSource(commit, parents[0], path, hash)
+			if err != nil {
+				return err
+			}
+			if renamed != "" {
+				path = renamed
+			}
+		}
+
+		return nil
+	})
+
+	return commits, err
+}
+
+/*
+ Get the hash of a file or directory in the tree of
+ a commit. If the path does not exist, the hash is zero.
+*/
+func commitPathHash(commit *object.Commit, path string) (plumbing.Hash, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if path == "." {
+		return tree.Hash, nil
+	}
+
+	entry, err := tree.FindEntry(path)
+	if err == object.ErrEntryNotFound ||
+		err == object.ErrDirectoryNotFound {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return entry.Hash, nil
+}
+
+/*
+ Find a file in the parent, which has the same content
+ as the added path and which was removed in the commit.
+*/
+func findRenameSource(
+	commit *object.Commit,
+	parent *object.Commit,
+	path string,
+	hash plumbing.Hash,
+) (string, error) {
+	parentHash, err := commitPathHash(parent, path)
+	if err != nil {
+		return "", err
+	}
+	if !parentHash.IsZero() {
+		// Not added in this commit
+		return "", nil
+	}
+
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return "", err
+	}
+
+	source := ""
+	err = parentTree.Files().ForEach(func(file *object.File) error {
+		if source != "" || file.Hash != hash {
+			return nil
+		}
+
+		// The source must be removed in the commit
+		fileHash, err := commitPathHash(commit, file.Name)
+		if err != nil {
+			return err
+		}
+		if fileHash.IsZero() {
+			source = file.Name
+		}
+		return nil
+	})
+
+	return source, err
+}
+
+/*
+ Make a gitbase commit from a go-git commit.
+ Author and committer are formatted like in
+ the raw git log output.
+*/
+func makeCommit(commit *object.Commit) *Commit {
+	parent := ""
+	if len(commit.ParentHashes) > 0 {
+		parent = commit.ParentHashes[0].String()
+	}
+
+	result := &Commit{
+		Id:        commit.Hash.String(),
+		Tree:      commit.TreeHash.String(),
+		Parent:    parent,
+		Author:    formatSignature(commit.Author),
+		Committer: formatSignature(commit.Committer),
+		Message:   strings.TrimSpace(commit.Message),
+		CreatedAt: commit.Author.When.UTC(),
+	}
+	result.parseMessage()
+
+	return result
+}
+
+func formatSignature(signature object.Signature) string {
+	return fmt.Sprintf(
+		"%s <%s> %d %s",
+		signature.Name,
+		signature.Email,
+		signature.When.Unix(),
+		signature.When.Format("-0700"),
+	)
+}
@@ -0,0 +1,120 @@
+package gitbase
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNativeHistoryMatchesGitCLI(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	collection, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := collection.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	archive.Put("source.lua", []byte("x = 1"), "add source")
+	archive.Put("other.lua", []byte("y = 1"), "add other")
+	archive.Put("source.lua", []byte("x = 2"), "update\n\nsource")
+	archive.Remove("source.lua", "remove source")
+
+	for _, key := range []string{
+		".",
+		"programs",
+		archive.Key("source.lua"),
+		archive.Key("other.lua"),
+	} {
+		repo.HistoryBackend = HistoryNative
+		native, err := repo.History(key)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		repo.HistoryBackend = HistoryGitCLI
+		cli, err := repo.History(key)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if len(native) != len(cli) {
+			t.Error(key, "Expected", len(cli), "commits, got:", len(native))
+			continue
+		}
+
+		for i := range cli {
+			if !reflect.DeepEqual(native[i], cli[i]) {
+				t.Error(key, "Expected:", cli[i], "got:", native[i])
+			}
+		}
+	}
+}
+
+func TestNativeHistoryFollowRenames(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	repo.Put("old.doc", []byte("foo"), "add document")
+	repo.Put("old.doc", []byte("foo bar"), "update document")
+
+	// Rename document
+	err = os.Rename(
+		filepath.Join(repo.BasePath, "old.doc"),
+		filepath.Join(repo.BasePath, "new.doc"),
+	)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := repo.CommitAll("rename document"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	history, err := repo.History("new.doc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(history) != 1 {
+		t.Error("Expected 1 commit without following renames, got:",
+			len(history))
+	}
+
+	repo.FollowRenames = true
+	history, err = repo.History("new.doc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(history) != 3 {
+		t.Error("Expected 3 commits when following renames, got:",
+			len(history))
+		return
+	}
+	if history[2].Message != "add document" {
+		t.Error("Unexpected first commit:", history[2].Message)
+	}
+}
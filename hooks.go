@@ -0,0 +1,323 @@
+package gitbase
+
+/*
+Hooks:
+Pre-write hooks are run before a document is written by
+Put and can reject or transform the document.
+Post-commit hooks are run after a commit with the
+changed keys.
+
+Hooks are registered for a key pattern (see path.Match).
+A pattern matches a key if it matches the key or any
+of its parent directories:
+
+    programs            all keys in the collection
+    programs/1/*.lua    lua documents in archive 1
+
+An empty pattern matches all keys.
+
+Example:
+
+    repo.Hooks().PreWrite("programs",
+        func(key string, document []byte) ([]byte, error) {
+            if path.Base(key) != "source.lua" {
+                return document, nil
+            }
+            return document, lua.Check(document)
+        })
+
+Hooks are shared with branch views. Post-commit hooks
+are run while the repository is locked and must not
+write to the repository.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"path"
+	"strings"
+	"sync"
+)
+
+/*
+ A pre-write hook returns the document to write, or
+ an error to reject the write.
+*/
+type PreWriteHook func(key string, document []byte) ([]byte, error)
+
+/*
+ A post-commit hook receives the commit and the
+ changed keys matching its pattern.
+*/
+type PostCommitHook func(commit *Commit, keys []string) error
+
+/*
+ A pre-write hook rejected a document,
+ nothing was written.
+*/
+type PreWriteHookError struct {
+	Pattern string
+	Key     string
+	Err     error
+}
+
+func (self *PreWriteHookError) Error() string {
+	return "pre-write hook " + self.Pattern +
+		" rejected " + self.Key + ": " + self.Err.Error()
+}
+
+func (self *PreWriteHookError) Unwrap() error {
+	return self.Err
+}
+
+/*
+ A post-commit hook failed, the commit
+ was made nevertheless.
+*/
+type PostCommitHookError struct {
+	Pattern  string
+	CommitId string
+	Err      error
+}
+
+func (self *PostCommitHookError) Error() string {
+	return "post-commit hook " + self.Pattern +
+		" failed for commit " + self.CommitId + ": " + self.Err.Error()
+}
+
+func (self *PostCommitHookError) Unwrap() error {
+	return self.Err
+}
+
+type preWriteHookEntry struct {
+	pattern string
+	hook    PreWriteHook
+}
+
+type postCommitHookEntry struct {
+	pattern string
+	hook    PostCommitHook
+}
+
+type HookRegistry struct {
+	sync.Mutex
+
+	preWrite   []preWriteHookEntry
+	postCommit []postCommitHookEntry
+}
+
+/*
+ Get the hook registry of the repository
+*/
+func (self *Repository) Hooks() *HookRegistry {
+	return &self.rootRepository().hooks
+}
+
+/*
+ Register a hook run before a document matching
+ the pattern is written. Hooks are run in the
+ order of registration.
+*/
+func (self *HookRegistry) PreWrite(pattern string, hook PreWriteHook) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	self.Lock()
+	defer self.Unlock()
+
+	self.preWrite = append(self.preWrite, preWriteHookEntry{
+		pattern: pattern,
+		hook:    hook,
+	})
+
+	return nil
+}
+
+/*
+ Register a hook run after a commit changing
+ keys matching the pattern.
+*/
+func (self *HookRegistry) PostCommit(pattern string, hook PostCommitHook) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+
+	self.Lock()
+	defer self.Unlock()
+
+	self.postCommit = append(self.postCommit, postCommitHookEntry{
+		pattern: pattern,
+		hook:    hook,
+	})
+
+	return nil
+}
+
+/*
+ Run all pre-write hooks matching the key
+*/
+func (self *HookRegistry) runPreWrite(
+	key string, document []byte,
+) ([]byte, error) {
+	self.Lock()
+	hooks := self.preWrite
+	self.Unlock()
+
+	key = path.Clean(key)
+	for _, entry := range hooks {
+		if !matchKeyPattern(entry.pattern, key) {
+			continue
+		}
+
+		result, err := entry.hook(key, document)
+		if err != nil {
+			return nil, &PreWriteHookError{
+				Pattern: entry.pattern,
+				Key:     key,
+				Err:     err,
+			}
+		}
+		document = result
+	}
+
+	return document, nil
+}
+
+/*
+ Run all post-commit hooks matching the changed keys.
+ All hooks are run, the first failure is returned.
+*/
+func (self *HookRegistry) runPostCommit(
+	commit *object.Commit, events []*ChangeEvent,
+) error {
+	self.Lock()
+	hooks := self.postCommit
+	self.Unlock()
+
+	var hookErr error
+	for _, entry := range hooks {
+		keys := []string{}
+		for _, event := range events {
+			if matchKeyPattern(entry.pattern, event.Key) {
+				keys = append(keys, event.Key)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		err := entry.hook(makeCommit(commit), keys)
+		if err != nil && hookErr == nil {
+			hookErr = &PostCommitHookError{
+				Pattern:  entry.pattern,
+				CommitId: commit.Hash.String(),
+				Err:      err,
+			}
+		}
+	}
+
+	return hookErr
+}
+
+/*
+ Check if any pre-write hook matches the key
+*/
+func (self *HookRegistry) matchPreWrite(key string) bool {
+	self.Lock()
+	defer self.Unlock()
+
+	key = path.Clean(key)
+	for _, entry := range self.preWrite {
+		if matchKeyPattern(entry.pattern, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self *HookRegistry) hasPostCommit() bool {
+	self.Lock()
+	defer self.Unlock()
+
+	return len(self.postCommit) > 0
+}
+
+/*
+ Check if the pattern matches the key
+ or one of its parent directories
+*/
+func matchKeyPattern(pattern, key string) bool {
+	if pattern == "" {
+		return true
+	}
+
+	components := strings.Split(key, "/")
+	for i := range components {
+		prefix := strings.Join(components[:i+1], "/")
+		if ok, _ := path.Match(pattern, prefix); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ Notify subscribers and run post-commit hooks
+ after a commit
+*/
+func (self *Repository) afterCommit(hash plumbing.Hash) error {
+	commit, err := self.gitRepo.CommitObject(hash)
+	if err != nil {
+		// The changes are committed nevertheless
+		self.logger().Error("could not read commit",
+			"commit_id", hash.String(), "error", err)
+		return nil
+	}
+
+	var parent *object.Commit
+	if commit.NumParents() > 0 {
+		parent, err = commit.Parent(0)
+		if err != nil {
+			self.logger().Error("could not read parent commit",
+				"commit_id", hash.String(), "error", err)
+			return nil
+		}
+	}
+
+	return self.notifyChanges(parent, commit)
+}
+
+/*
+ Notify subscribers and run post-commit hooks for
+ the changes between two commits
+*/
+func (self *Repository) notifyChanges(from, to *object.Commit) error {
+	hooks := self.Hooks()
+	if self.subscriptions.empty() && !hooks.hasPostCommit() {
+		return nil
+	}
+
+	events, err := changeEvents(from, to)
+	if err != nil {
+		// The changes are committed nevertheless
+		self.logger().Error("could not get changes",
+			"commit_id", to.Hash.String(), "error", err)
+		return nil
+	}
+
+	self.subscriptions.publish(events)
+
+	return hooks.runPostCommit(to, events)
+}
+
+/*
+ Check if a commit error leaves the commit in place
+*/
+func isCommitted(err error) bool {
+	_, ok := err.(*PostCommitHookError)
+	return ok
+}
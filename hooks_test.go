@@ -0,0 +1,156 @@
+package gitbase
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRepositoryHooks(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	errSyntax := errors.New("syntax error")
+
+	// Reject invalid sources
+	err = repo.Hooks().PreWrite("programs/*/source.lua",
+		func(key string, document []byte) ([]byte, error) {
+			if strings.Contains(string(document), "{") {
+				return nil, errSyntax
+			}
+			return document, nil
+		})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Transform documents of the collection
+	repo.Hooks().PreWrite("programs",
+		func(key string, document []byte) ([]byte, error) {
+			return []byte(strings.TrimSpace(string(document))), nil
+		})
+
+	// Invalid patterns are rejected
+	if err := repo.Hooks().PreWrite("[", nil); err == nil {
+		t.Error("Expected invalid pattern error")
+	}
+
+	changed := []string{}
+	repo.Hooks().PostCommit("programs/*/source.lua",
+		func(commit *Commit, keys []string) error {
+			if commit.Id == "" {
+				t.Error("Expected commit id")
+			}
+			changed = append(changed, keys...)
+			return nil
+		})
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = archive.Put("source.lua", []byte("x = {"), "add broken source")
+	hookErr, ok := err.(*PreWriteHookError)
+	if !ok {
+		t.Error("Expected pre-write hook error, got:", err)
+		return
+	}
+	if hookErr.Key != archive.Key("source.lua") || hookErr.Err != errSyntax {
+		t.Error("Unexpected hook error:", hookErr)
+	}
+
+	if _, err := archive.Fetch("source.lua"); err == nil {
+		t.Error("Expected rejected document not to be written")
+	}
+	if len(changed) != 0 {
+		t.Error("Expected no post-commit hook call, got:", changed)
+	}
+
+	// Valid document
+	if err := archive.Put("source.lua", []byte("x = 1\n\n"), "add"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := archive.Fetch("source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "x = 1" {
+		t.Error("Expected transformed document, got:", string(res))
+	}
+
+	if len(changed) != 1 || changed[0] != archive.Key("source.lua") {
+		t.Error("Unexpected changed keys:", changed)
+	}
+
+	// Post-commit hook failures are reported,
+	// the commit is kept.
+	errBuild := errors.New("build failed")
+	repo.Hooks().PostCommit("programs",
+		func(commit *Commit, keys []string) error {
+			return errBuild
+		})
+
+	rev, err := archive.PutIfRevision(
+		"source.lua", []byte("x = 2"), latestRevision(t, archive, "source.lua"), "update")
+	postErr, ok := err.(*PostCommitHookError)
+	if !ok {
+		t.Error("Expected post-commit hook error, got:", err)
+		return
+	}
+	if postErr.CommitId != rev || postErr.Err != errBuild {
+		t.Error("Unexpected hook error:", postErr)
+	}
+
+	res, _ = archive.Fetch("source.lua")
+	if string(res) != "x = 2" {
+		t.Error("Expected committed document, got:", string(res))
+	}
+}
+
+func latestRevision(t *testing.T, archive *Archive, key string) string {
+	revisions, err := archive.Revisions(key)
+	if err != nil || len(revisions) == 0 {
+		t.Error("Could not get revisions:", err)
+		return ""
+	}
+	return revisions[0]
+}
+
+func TestMatchKeyPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"", "programs/1/source.lua", true},
+		{"programs", "programs/1/source.lua", true},
+		{"programs/*", "programs/1/source.lua", true},
+		{"programs/*/source.lua", "programs/1/source.lua", true},
+		{"programs/*/source.lua", "programs/1/config.lua", false},
+		{"scripts", "programs/1/source.lua", false},
+		{"prog", "programs/1/source.lua", false},
+	}
+
+	for _, c := range cases {
+		if matchKeyPattern(c.pattern, c.key) != c.match {
+			t.Error("Unexpected match result for", c.pattern, c.key)
+		}
+	}
+}
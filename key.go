@@ -0,0 +1,72 @@
+package gitbase
+
+/*
+Key validation:
+Document keys and collection names are mapped onto
+paths in the worktree. Keys must not escape the
+repository or interfere with git.
+
+A valid key
+
+  * is a relative path, using / as separator
+  * has no empty, . or .. components
+  * has no .git component (in any case)
+  * does not use names reserved by git or gitbase
+  * has no control characters or backslashes
+
+*/
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+var reservedNames = map[string]bool{
+	".git":           true,
+	".gitkeep":       true,
+	".gitignore":     true,
+	".gitattributes": true,
+	".gitmodules":    true,
+}
+
+/*
+ Check if a key is a valid path relative to
+ the repository base path.
+*/
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return ErrInvalidKey
+	}
+
+	for _, c := range key {
+		if c < 0x20 || c == 0x7f || c == '\\' {
+			return ErrInvalidKey
+		}
+	}
+
+	for _, component := range strings.Split(key, "/") {
+		if component == "" || component == "." || component == ".." {
+			return ErrInvalidKey
+		}
+		if reservedNames[strings.ToLower(component)] {
+			return ErrInvalidKey
+		}
+	}
+
+	return nil
+}
+
+/*
+ Check if a name is a valid single path component,
+ as used for collection names and archive documents.
+*/
+func ValidateName(name string) error {
+	if strings.Contains(name, "/") {
+		return ErrInvalidKey
+	}
+	return ValidateKey(name)
+}
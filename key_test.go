@@ -0,0 +1,146 @@
+package gitbase
+
+import (
+	"os"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := map[string]bool{
+		"hello.doc":             true,
+		"programs/1/source.lua": true,
+		"a/.hidden":             true,
+		"..foo":                 true,
+		"":                      false,
+		"/etc/passwd":           false,
+		"../../etc/passwd":      false,
+		"programs/../../x":      false,
+		"programs/./x":          false,
+		"programs//x":           false,
+		"programs/":             false,
+		".":                     false,
+		".git":                  false,
+		".GIT/config":           false,
+		"programs/.git/HEAD":    false,
+		"programs/1/.gitkeep":   false,
+		".gitattributes":        false,
+		"foo\x00bar":            false,
+		"foo\nbar":              false,
+		"foo\x7fbar":            false,
+		"..\\..\\foo":           false,
+	}
+
+	for key, expected := range tests {
+		err := ValidateKey(key)
+		if expected && err != nil {
+			t.Errorf("Expected %q to be valid, got: %v", key, err)
+		}
+		if !expected && err != ErrInvalidKey {
+			t.Errorf("Expected %q to be invalid, got: %v", key, err)
+		}
+	}
+
+	if err := ValidateName("programs/1"); err != ErrInvalidKey {
+		t.Error("Expected name with separator to be invalid, got:", err)
+	}
+}
+
+func TestInvalidKeyEntryPoints(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	// Repository
+	key := "../escaped.doc"
+	if err := repo.Put(key, []byte("x"), "escape"); err != ErrInvalidKey {
+		t.Error("Put: expected ErrInvalidKey, got:", err)
+	}
+	if _, err := repo.Fetch(key); err != ErrInvalidKey {
+		t.Error("Fetch: expected ErrInvalidKey, got:", err)
+	}
+	if _, err := repo.FetchRevision(key, "HEAD"); err != ErrInvalidKey {
+		t.Error("FetchRevision: expected ErrInvalidKey, got:", err)
+	}
+	if err := repo.Remove(key, "escape"); err != ErrInvalidKey {
+		t.Error("Remove: expected ErrInvalidKey, got:", err)
+	}
+	if _, err := repo.History(key); err != ErrInvalidKey {
+		t.Error("History: expected ErrInvalidKey, got:", err)
+	}
+	if _, err := repo.Revisions(key); err != ErrInvalidKey {
+		t.Error("Revisions: expected ErrInvalidKey, got:", err)
+	}
+
+	// Collections
+	for _, name := range []string{".git", "..", "a/b", ".hidden", ""} {
+		if _, err := repo.Create(name, "create"); err != ErrInvalidKey {
+			t.Error("Create: expected ErrInvalidKey, got:", err)
+		}
+		if _, err := repo.Open(name); err != ErrInvalidKey {
+			t.Error("Open: expected ErrInvalidKey, got:", err)
+		}
+		if _, err := repo.Use(name); err != ErrInvalidKey {
+			t.Error("Use: expected ErrInvalidKey, got:", err)
+		}
+	}
+
+	// Archives
+	collection, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := collection.NextArchive("new archive")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, key := range []string{"../../escaped.doc", "../2/foo", ".gitkeep"} {
+		if err := archive.Put(key, []byte("x"), "escape"); err != ErrInvalidKey {
+			t.Error("Archive.Put: expected ErrInvalidKey, got:", err)
+		}
+		if _, err := archive.Fetch(key); err != ErrInvalidKey {
+			t.Error("Archive.Fetch: expected ErrInvalidKey, got:", err)
+		}
+		if _, err := archive.FetchRevision(key, "HEAD"); err != ErrInvalidKey {
+			t.Error("Archive.FetchRevision: expected ErrInvalidKey, got:", err)
+		}
+		if err := archive.Remove(key, "escape"); err != ErrInvalidKey {
+			t.Error("Archive.Remove: expected ErrInvalidKey, got:", err)
+		}
+		if _, err := archive.History(key); err != ErrInvalidKey {
+			t.Error("Archive.History: expected ErrInvalidKey, got:", err)
+		}
+		if _, err := archive.Revisions(key); err != ErrInvalidKey {
+			t.Error("Archive.Revisions: expected ErrInvalidKey, got:", err)
+		}
+	}
+
+	// Transactions
+	err = repo.Transaction("escape", func(tx *Tx) error {
+		if err := tx.Put(key, []byte("x")); err != ErrInvalidKey {
+			t.Error("Tx.Put: expected ErrInvalidKey, got:", err)
+		}
+		if err := tx.Remove(key); err != ErrInvalidKey {
+			t.Error("Tx.Remove: expected ErrInvalidKey, got:", err)
+		}
+		if _, err := tx.CreateCollection(".git"); err != ErrInvalidKey {
+			t.Error("Tx.CreateCollection: expected ErrInvalidKey, got:", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Nothing should have been written outside of the repository
+	if _, err := os.Stat(path + "/../escaped.doc"); err == nil {
+		t.Error("Document escaped the repository")
+	}
+}
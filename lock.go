@@ -0,0 +1,316 @@
+package gitbase
+
+/*
+Repository locking:
+The embedded mutex of the repository only protects against
+concurrent access within a process. Mutating operations
+additionally acquire an advisory lock file in the git
+directory, which holds the PID and the hostname of the
+owning process and a random token:
+
+    4242 worker-1 8f0c2a...
+
+If the owning process does not exist anymore, the lock
+is considered stale and will be removed. PIDs are only
+meaningful on the host which took the lock, locks of
+other hosts are never reclaimed. Removing stale
+locks is serialized with an OS file lock (flock or
+LockFileEx) on a separate file, so a lock replaced by
+another process in the meantime is never removed.
+
+Waiting for the lock can be cancelled with a context.
+*/
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRepositoryLocked = errors.New("repository is locked by another process")
+)
+
+const (
+	DefaultLockTimeout = 10 * time.Second
+
+	lockFilename     = "gitbase.lock"
+	reclaimSuffix    = ".reclaim"
+	lockPollInterval = 20 * time.Millisecond
+)
+
+func (self *Repository) lockPath() string {
+	return filepath.Join(self.gitDir, lockFilename)
+}
+
+/*
+ Acquire the repository lock for a mutating operation.
+ Release the lock with unlock.
+*/
+func (self *Repository) lock() error {
+	return self.lockContext(context.Background())
+}
+
+/*
+ Acquire the repository lock, give up waiting
+ when the context is done.
+*/
+func (self *Repository) lockContext(ctx context.Context) error {
+	if self.commit != nil {
+		return ErrReadOnly
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := self.lockMutex(ctx); err != nil {
+		return err
+	}
+
+	token, err := acquireLockFile(ctx, self.lockPath(), self.LockTimeout)
+	if err != nil {
+		self.Unlock()
+		return err
+	}
+	self.lockToken = token
+
+	return nil
+}
+
+/*
+ Lock the mutex of the repository. If the context
+ is done first, the mutex is released as soon as
+ it is acquired.
+*/
+func (self *Repository) lockMutex(ctx context.Context) error {
+	if ctx.Done() == nil {
+		self.Lock()
+		return nil
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		self.Lock()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-acquired
+			self.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+func (self *Repository) unlock() {
+	releaseLockFile(self.lockPath(), self.lockToken)
+	self.lockToken = ""
+	self.Unlock()
+}
+
+/*
+ Create the lock file, wait until timeout
+ if it is held by another process. The token
+ of the lock is returned.
+*/
+func acquireLockFile(
+	ctx context.Context, path string, timeout time.Duration,
+) (string, error) {
+	if timeout <= 0 {
+		timeout = DefaultLockTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		token, err := createLockFile(path)
+		if err == nil {
+			return token, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+
+		if lockFileIsStale(path, timeout) {
+			if err := reclaimLockFile(path, timeout); err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return "", ErrRepositoryLocked
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+/*
+ Remove the lock file, if it still holds our token.
+ A lock reclaimed by another process is kept.
+*/
+func releaseLockFile(path, token string) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	owner, ok := parseLockFile(content)
+	if !ok || token == "" || owner.token != token {
+		return
+	}
+
+	os.Remove(path)
+}
+
+/*
+ Remove a stale lock file. Only one process at a time
+ may reclaim a lock, so the lock is checked again before
+ it is removed: if another process reclaimed it first and
+ created a new lock, the new lock is not stale.
+*/
+func reclaimLockFile(path string, timeout time.Duration) error {
+	guard, err := os.OpenFile(path+reclaimSuffix, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer guard.Close()
+
+	if err := lockFile(guard); err != nil {
+		return err
+	}
+	defer unlockFile(guard)
+
+	if !lockFileIsStale(path, timeout) {
+		return nil
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+/*
+ Create the lock file, the token identifying
+ the lock is returned.
+*/
+func createLockFile(path string) (string, error) {
+	token, err := makeLockToken()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = fmt.Fprintf(f, "%d %s %s\n", os.Getpid(), lockHostname(), token)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return token, nil
+}
+
+func makeLockToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+/*
+ Get the hostname recorded in lock files
+*/
+func lockHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" ||
+		strings.ContainsAny(hostname, " \t\r\n") {
+		return "unknown"
+	}
+	return hostname
+}
+
+type lockOwner struct {
+	pid   int
+	host  string
+	token string
+}
+
+/*
+ Parse the content of a lock file. Lock files of
+ earlier versions only hold the PID, the host
+ is empty then.
+*/
+func parseLockFile(content []byte) (*lockOwner, bool) {
+	fields := strings.Fields(string(content))
+	if len(fields) != 1 && len(fields) != 3 {
+		return nil, false
+	}
+
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return nil, false
+	}
+
+	owner := &lockOwner{pid: pid}
+	if len(fields) == 3 {
+		owner.host = fields[1]
+		owner.token = fields[2]
+	}
+
+	return owner, true
+}
+
+/*
+ A lock is stale if the owning process on this host is
+ gone. Locks of other hosts are never stale. If the lock
+ can not be read, it is stale when it is older than
+ the lock timeout.
+*/
+func lockFileIsStale(path string, timeout time.Duration) bool {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false // Released in the meantime
+	}
+
+	owner, ok := parseLockFile(content)
+	if err != nil || !ok {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+		return time.Since(info.ModTime()) > timeout
+	}
+
+	if owner.host != "" && owner.host != lockHostname() {
+		return false
+	}
+
+	return !processExists(owner.pid)
+}
@@ -0,0 +1,207 @@
+package gitbase
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRepositoryLocked(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepositoryWithOptions(path, &RepositoryOptions{
+		LockTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	// Simulate another process holding the lock
+	// by using the PID of the parent process.
+	err = ioutil.WriteFile(
+		repo.lockPath(), []byte(strconv.Itoa(os.Getppid())), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = repo.Put("test.doc", []byte("foo"), "added test document")
+	if err != ErrRepositoryLocked {
+		t.Error("Expected ErrRepositoryLocked, got:", err)
+	}
+
+	// Release the lock while waiting
+	repo.LockTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.Remove(repo.lockPath())
+	}()
+
+	err = repo.Put("test.doc", []byte("foo"), "added test document")
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The lock should be released
+	if _, err := os.Stat(repo.lockPath()); !os.IsNotExist(err) {
+		t.Error("Expected lock file to be removed")
+	}
+}
+
+func TestRepositoryStaleLock(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepositoryWithOptions(path, &RepositoryOptions{
+		LockTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	// A process with this PID should not exist
+	err = ioutil.WriteFile(repo.lockPath(), []byte("2147483646\n"), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = repo.Put("test.doc", []byte("foo"), "added test document")
+	if err != nil {
+		t.Error("Expected stale lock to be removed, got:", err)
+	}
+}
+
+func TestRepositoryLockOtherHost(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepositoryWithOptions(path, &RepositoryOptions{
+		LockTimeout: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	// The PID does not exist on this host, but the lock
+	// was taken by a process on another host.
+	lock := []byte("2147483646 " + lockHostname() + "-other 0123abcd\n")
+	if err := ioutil.WriteFile(repo.lockPath(), lock, 0644); err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = repo.Put("test.doc", []byte("foo"), "added test document")
+	if err != ErrRepositoryLocked {
+		t.Error("Expected ErrRepositoryLocked, got:", err)
+	}
+	content, _ := ioutil.ReadFile(repo.lockPath())
+	if string(content) != string(lock) {
+		t.Error("Expected lock of other host to be kept, got:", string(content))
+	}
+	os.Remove(repo.lockPath())
+
+	// Only our own lock is removed when unlocking
+	if err := repo.lock(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := ioutil.WriteFile(repo.lockPath(), lock, 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	repo.unlock()
+	if _, err := os.Stat(repo.lockPath()); err != nil {
+		t.Error("Expected lock of another process to be kept:", err)
+	}
+	os.Remove(repo.lockPath())
+
+	if err := repo.lock(); err != nil {
+		t.Error(err)
+		return
+	}
+	content, _ = ioutil.ReadFile(repo.lockPath())
+	owner, ok := parseLockFile(content)
+	if !ok || owner.pid != os.Getpid() || owner.host != lockHostname() {
+		t.Error("Unexpected lock file:", string(content))
+	}
+	repo.unlock()
+	if _, err := os.Stat(repo.lockPath()); !os.IsNotExist(err) {
+		t.Error("Expected own lock to be removed")
+	}
+}
+
+func TestAcquireStaleLockRace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gitbase-test-lock")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, lockFilename)
+	ctx := context.Background()
+
+	// Another locker reclaimed the stale lock and holds a new
+	// lock, after it was found stale: it must not be removed.
+	if _, err := createLockFile(path); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := reclaimLockFile(path, time.Second); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("Expected live lock to be kept:", err)
+	}
+	os.Remove(path)
+
+	for round := 0; round < 50; round++ {
+		// A process with this PID should not exist
+		err := ioutil.WriteFile(path, []byte("2147483646\n"), 0644)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		var (
+			holders int32
+			wg      sync.WaitGroup
+			start   = make(chan struct{})
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+
+				if _, err := acquireLockFile(ctx, path, time.Second); err != nil {
+					t.Error(err)
+					return
+				}
+				if n := atomic.AddInt32(&holders, 1); n != 1 {
+					t.Error("Lock is held by", n, "lockers")
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&holders, -1)
+				os.Remove(path)
+			}()
+		}
+
+		close(start)
+		wg.Wait()
+		if t.Failed() {
+			return
+		}
+	}
+}
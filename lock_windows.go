@@ -0,0 +1,54 @@
+//go:build windows
+// +build windows
+
+package gitbase
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const lockfileExclusiveLock = 0x2
+
+func processExists(pid int) bool {
+	// On windows, finding a process fails if it does not exist
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+/*
+ Acquire an exclusive OS lock on the file, it is
+ released when the process exits.
+*/
+func lockFile(f *os.File) error {
+	overlapped := &syscall.Overlapped{}
+	r, _, err := procLockFileEx.Call(
+		f.Fd(), lockfileExclusiveLock, 0, 1, 0,
+		uintptr(unsafe.Pointer(overlapped)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	overlapped := &syscall.Overlapped{}
+	r, _, err := procUnlockFileEx.Call(
+		f.Fd(), 0, 1, 0,
+		uintptr(unsafe.Pointer(overlapped)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
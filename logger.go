@@ -0,0 +1,98 @@
+package gitbase
+
+/*
+Logging:
+Messages are logged with a level and structured fields
+as alternating keys and values, like log/slog:
+
+    logger.Warn("non numeric entry in archives path",
+        "collection", "programs", "entry", "latest")
+
+A *slog.Logger can be used as Logger:
+
+    opts := DefaultRepositoryOptions()
+    opts.Logger = slog.Default()
+
+If no logger is set, DefaultLogger is used, which
+writes to the standard logger of the log package.
+Use DiscardLogger to silence the repository.
+*/
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+/*
+ A logger receives leveled messages with fields,
+ the method set is compatible with *slog.Logger.
+*/
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+var (
+	// Used if no logger is set
+	DefaultLogger Logger = StdLogger{}
+
+	DiscardLogger Logger = discardLogger{}
+)
+
+/*
+ The std logger writes to the standard logger
+ of the log package:
+
+    INFO destroying collection collection=programs
+*/
+type StdLogger struct{}
+
+func (self StdLogger) Debug(msg string, args ...interface{}) {
+	logStd("DEBUG", msg, args)
+}
+
+func (self StdLogger) Info(msg string, args ...interface{}) {
+	logStd("INFO", msg, args)
+}
+
+func (self StdLogger) Warn(msg string, args ...interface{}) {
+	logStd("WARN", msg, args)
+}
+
+func (self StdLogger) Error(msg string, args ...interface{}) {
+	logStd("ERROR", msg, args)
+}
+
+func logStd(level, msg string, args []interface{}) {
+	line := []string{level, msg}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			// A value without key
+			line = append(line, fmt.Sprintf("!BADKEY=%v", args[i]))
+			break
+		}
+		line = append(line, fmt.Sprintf("%v=%v", args[i], args[i+1]))
+	}
+	log.Println(strings.Join(line, " "))
+}
+
+type discardLogger struct{}
+
+func (self discardLogger) Debug(msg string, args ...interface{}) {}
+func (self discardLogger) Info(msg string, args ...interface{})  {}
+func (self discardLogger) Warn(msg string, args ...interface{})  {}
+func (self discardLogger) Error(msg string, args ...interface{}) {}
+
+/*
+ Get the logger of the repository, fall back
+ to the default logger if not set.
+*/
+func (self *Repository) logger() Logger {
+	if self == nil || self.Logger == nil {
+		return DefaultLogger
+	}
+	return self.Logger
+}
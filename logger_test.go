@@ -0,0 +1,206 @@
+package gitbase
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type logEntry struct {
+	level string
+	msg   string
+	args  []interface{}
+}
+
+type testLogger struct {
+	sync.Mutex
+	entries []logEntry
+}
+
+func (self *testLogger) log(level, msg string, args []interface{}) {
+	self.Lock()
+	defer self.Unlock()
+	self.entries = append(self.entries, logEntry{level, msg, args})
+}
+
+func (self *testLogger) Debug(msg string, args ...interface{}) {
+	self.log("DEBUG", msg, args)
+}
+
+func (self *testLogger) Info(msg string, args ...interface{}) {
+	self.log("INFO", msg, args)
+}
+
+func (self *testLogger) Warn(msg string, args ...interface{}) {
+	self.log("WARN", msg, args)
+}
+
+func (self *testLogger) Error(msg string, args ...interface{}) {
+	self.log("ERROR", msg, args)
+}
+
+func (self *testLogger) find(msg string) *logEntry {
+	self.Lock()
+	defer self.Unlock()
+	for _, entry := range self.entries {
+		if entry.msg == msg {
+			return &entry
+		}
+	}
+	return nil
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	StdLogger{}.Warn("unknown token", "commit_id", "abc", "token", "foo")
+	if !strings.HasSuffix(buf.String(),
+		"WARN unknown token commit_id=abc token=foo\n") {
+		t.Error("Unexpected log output:", buf.String())
+	}
+
+	buf.Reset()
+	StdLogger{}.Info("odd", "key")
+	if !strings.HasSuffix(buf.String(), "INFO odd !BADKEY=key\n") {
+		t.Error("Unexpected log output:", buf.String())
+	}
+
+	buf.Reset()
+	DiscardLogger.Error("discarded", "key", "value")
+	if buf.Len() != 0 {
+		t.Error("Expected no output, got:", buf.String())
+	}
+}
+
+func TestRepositoryLogger(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	logger := &testLogger{}
+	opts := DefaultRepositoryOptions()
+	opts.Logger = logger
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+	if logger.find("initializing repository") == nil {
+		t.Error("Expected initialization to be logged")
+	}
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	os.MkdirAll(programs.Path()+"/latest", 0755)
+	if _, err := programs.Archives(); err != nil {
+		t.Error(err)
+	}
+	entry := logger.find(
+		"non numeric entry in archives path, check the repository with Verify")
+	if entry == nil || entry.level != "WARN" {
+		t.Error("Expected warning, got:", entry)
+	} else if entry.args[1] != "programs" || entry.args[3] != "latest" {
+		t.Error("Unexpected fields:", entry.args)
+	}
+	os.Remove(programs.Path() + "/latest")
+
+	// Read-only views can not destroy archives
+	view, err := repo.At("HEAD")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	viewPrograms, err := view.Open("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	logger.entries = nil
+	viewArchive := &Archive{Id: archive.Id, Collection: viewPrograms}
+	if err := viewArchive.Destroy(""); err != ErrReadOnly {
+		t.Error("Expected ErrReadOnly, got:", err)
+	}
+	if len(logger.entries) != 0 {
+		t.Error("Expected no log entries, got:", logger.entries)
+	}
+
+	if err := archive.Destroy(""); err != nil {
+		t.Error(err)
+		return
+	}
+	entry = logger.find("destroying archive")
+	if entry == nil || entry.level != "INFO" {
+		t.Error("Expected info, got:", entry)
+	} else if entry.args[3] != uint64(1) {
+		t.Error("Unexpected fields:", entry.args)
+	}
+
+	// Failed destructions are not logged
+	logger.entries = nil
+	readOnly, err := repo.At("HEAD")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readOnlyPrograms, err := readOnly.Open("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := readOnlyPrograms.Destroy(""); err != ErrReadOnly {
+		t.Error("Expected ErrReadOnly, got:", err)
+	}
+	if len(logger.entries) != 0 {
+		t.Error("Expected no log entries, got:", logger.entries)
+	}
+
+	if err := programs.Destroy(""); err != nil {
+		t.Error(err)
+		return
+	}
+	if logger.find("destroying collection") == nil {
+		t.Error("Expected destruction to be logged")
+	}
+	logger.entries = nil
+	if err := programs.Destroy(""); err != ErrCollectionDoesNotExist {
+		t.Error("Expected ErrCollectionDoesNotExist, got:", err)
+	}
+	if err := archive.Destroy(""); err != ErrArchiveDoesNotExist {
+		t.Error("Expected ErrArchiveDoesNotExist, got:", err)
+	}
+	if len(logger.entries) != 0 {
+		t.Error("Expected no log entries, got:", logger.entries)
+	}
+
+	// Views and branches use the logger of the repository
+	view, err = repo.At("HEAD")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if view.logger() != logger {
+		t.Error("Expected logger of view to be set")
+	}
+
+	// The git log parser
+	logger.entries = nil
+	parseGitLog(logger, []byte("commit abc\nfoo bar\n"), nil)
+	entry = logger.find("unknown token in git log")
+	if entry == nil || entry.args[1] != "abc" || entry.args[3] != "foo" {
+		t.Error("Expected unknown token warning, got:", entry)
+	}
+}
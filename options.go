@@ -0,0 +1,159 @@
+package gitbase
+
+/*
+Options for configuring the repository and
+individual write operations.
+*/
+
+import (
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"time"
+)
+
+/*
+ An identity is used as author or committer of
+ a change in the repository.
+*/
+type Identity struct {
+	Name  string
+	Email string
+}
+
+var DefaultIdentity = Identity{
+	Name:  "gitbase",
+	Email: "git@gitbase",
+}
+
+func (self Identity) IsZero() bool {
+	return self.Name == "" && self.Email == ""
+}
+
+/*
+ Make a git signature for this identity
+*/
+func (self Identity) Signature(when time.Time) *object.Signature {
+	return &object.Signature{
+		Name:  self.Name,
+		Email: self.Email,
+		When:  when,
+	}
+}
+
+/*
+ Repository options are passed to NewRepositoryWithOptions.
+*/
+type RepositoryOptions struct {
+	// The default author of all changes.
+	// If not set, DefaultIdentity is used.
+	Author Identity
+
+	// The committer of all changes.
+	// If not set, the author is used.
+	Committer Identity
+
+	// Select the implementation of History. The default
+	// is HistoryNative, HistoryGitCLI requires git in PATH.
+	HistoryBackend HistoryBackend
+
+	// Follow renames of documents in History
+	FollowRenames bool
+
+	// Maximum time to wait for the repository lock
+	// held by another process. If not set,
+	// DefaultLockTimeout is used.
+	LockTimeout time.Duration
+
+	// Sign all commits and snapshots with this key,
+	// see ReadSignKey.
+	SignKey *openpgp.Entity
+
+	// Verify commit signatures in History against
+	// this keyring, see ReadKeyring.
+	Keyring openpgp.EntityList
+
+	// Documents larger than the threshold (in bytes) are
+	// stored in the blob store, only a pointer is committed.
+	// Offloading is disabled if not set.
+	BlobThreshold int64
+
+	// Location of the blob store. If not set, the store is
+	// located next to the repository: /path/to/repo.blobs
+	BlobStorePath string
+
+	// Encrypt the documents of collections with the keys
+	// of their key provider, see KeyProvider.
+	Encryption map[string]KeyProvider
+
+	// Handling of uncommitted changes found when opening
+	// the repository. The default is DirtyWorktreeFail.
+	DirtyWorktree DirtyWorktreePolicy
+
+	// Log messages of the repository are written to the
+	// logger. If not set, DefaultLogger is used.
+	Logger Logger
+}
+
+/*
+ Get the default repository options
+*/
+func DefaultRepositoryOptions() *RepositoryOptions {
+	return &RepositoryOptions{
+		Author: DefaultIdentity,
+	}
+}
+
+/*
+ Write options can be passed to every operation
+ creating a commit in the repository.
+*/
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	author   *Identity
+	parents  []plumbing.Hash
+	trailers map[string]string
+}
+
+func makeWriteOptions(opts []WriteOption) *writeOptions {
+	options := &writeOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+/*
+ Get the author of a change, fall back to the
+ default identity if not provided.
+*/
+func (self *writeOptions) authorOr(identity Identity) Identity {
+	if self.author != nil {
+		return *self.author
+	}
+	return identity
+}
+
+/*
+ Attribute a change to an author other than the
+ repository's default author.
+*/
+func WithAuthor(name, email string) WriteOption {
+	return func(options *writeOptions) {
+		options.author = &Identity{
+			Name:  name,
+			Email: email,
+		}
+	}
+}
+
+/*
+ Set the parents of a commit, e.g. for merges
+*/
+func withParents(parents ...plumbing.Hash) WriteOption {
+	return func(options *writeOptions) {
+		options.parents = parents
+	}
+}
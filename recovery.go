@@ -0,0 +1,267 @@
+package gitbase
+
+/*
+Crash recovery:
+A crash between writing a document and committing it
+leaves uncommitted changes in the worktree. When the
+repository is opened, these changes are reconciled
+according to the DirtyWorktreePolicy of the
+repository options:
+
+    DirtyWorktreeFail      fail with a DirtyWorktreeError
+    DirtyWorktreeReset     restore the changed paths to HEAD
+    DirtyWorktreeCommit    commit the changes as "recovery"
+
+Failing is the default. DirtyWorktreeCommit and
+DirtyWorktreeReset only handle changes the repository
+could have written: changes of tracked documents and new
+documents of collections and archives. Other new files,
+e.g. a README, are left untouched.
+
+Leftover temporary files of atomic writes are
+always removed.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ The dirty worktree policy selects how uncommitted
+ changes are handled when opening the repository.
+*/
+type DirtyWorktreePolicy int
+
+const (
+	DirtyWorktreeFail DirtyWorktreePolicy = iota
+	DirtyWorktreeReset
+	DirtyWorktreeCommit
+)
+
+// The reason of commits made by DirtyWorktreeCommit
+const RecoveryReason = "recovery"
+
+/*
+ The worktree has uncommitted changes in
+ the listed paths.
+*/
+type DirtyWorktreeError struct {
+	Paths []string
+}
+
+func (self *DirtyWorktreeError) Error() string {
+	return "worktree has uncommitted changes: " +
+		strings.Join(self.Paths, ", ")
+}
+
+/*
+ Get the paths with uncommitted changes in the
+ worktree or the index, sorted by path.
+*/
+func (self *Repository) dirtyPaths() ([]string, error) {
+	status, err := self.Worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	return changedPaths(status), nil
+}
+
+/*
+ Get the paths of a status with changes in the
+ worktree or the index, sorted by path.
+*/
+func changedPaths(status git.Status) []string {
+	paths := []string{}
+	for path, fileStatus := range status {
+		if fileStatus.Staging == git.Unmodified &&
+			fileStatus.Worktree == git.Unmodified {
+			continue
+		}
+		paths = append(paths, filepath.FromSlash(path))
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+/*
+ Reconcile uncommitted changes in the worktree
+ with the history, see DirtyWorktreePolicy.
+*/
+func (self *Repository) reconcileWorktree(policy DirtyWorktreePolicy) error {
+	paths, err := self.dirtyPaths()
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+
+	// The changes may belong to a write in progress
+	// of another process, wait until it is done.
+	if err := self.lock(); err != nil {
+		return err
+	}
+	defer self.unlock()
+
+	if err := self.removeTempFiles(paths); err != nil {
+		return err
+	}
+
+	paths, err = self.dirtyPaths()
+	if err != nil || len(paths) == 0 {
+		return err
+	}
+
+	switch policy {
+	case DirtyWorktreeReset:
+		return self.resetPaths(paths)
+	case DirtyWorktreeCommit:
+		return self.commitRecovery(paths)
+	}
+
+	return &DirtyWorktreeError{Paths: paths}
+}
+
+/*
+ Commit the changes of the paths the repository could
+ have written, other new files are left untouched.
+*/
+func (self *Repository) commitRecovery(paths []string) error {
+	recovered, err := self.recoverablePaths(paths)
+	if err != nil || len(recovered) == 0 {
+		return err
+	}
+
+	// Stage only the recovered paths
+	if err := self.stageDocuments(recovered...); err != nil {
+		return err
+	}
+
+	return self.Commit(RecoveryReason)
+}
+
+/*
+ Get the dirty paths the repository could have written:
+ tracked documents and new paths of the layout. Other new
+ files are logged and removed from the index, but are
+ kept in the worktree.
+*/
+func (self *Repository) recoverablePaths(paths []string) ([]string, error) {
+	status, err := self.Worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	recovered := []string{}
+	skipped := []string{}
+	for _, key := range paths {
+		if !isRecoverable(status, key) {
+			skipped = append(skipped, key)
+			continue
+		}
+		recovered = append(recovered, key)
+	}
+
+	if len(skipped) > 0 {
+		self.logger().Warn("leaving files outside of collections untouched",
+			"paths", strings.Join(skipped, ", "))
+
+		// Files staged by someone else stay untracked
+		if err := self.unstagePaths(skipped...); err != nil {
+			return nil, err
+		}
+	}
+
+	return recovered, nil
+}
+
+/*
+ Check if the repository could have written a dirty path:
+ a tracked document or a new path of the layout.
+*/
+func isRecoverable(status git.Status, key string) bool {
+	fileStatus, ok := status[filepath.ToSlash(key)]
+	if !ok {
+		return isLayoutPath(key)
+	}
+
+	isNew := fileStatus.Worktree == git.Untracked ||
+		fileStatus.Staging == git.Added
+	return !isNew || isLayoutPath(key)
+}
+
+/*
+ Check if a new path is part of the layout of collections
+ and archives: <collection>/.gitkeep or <collection>/<id>/<name>
+*/
+func isLayoutPath(key string) bool {
+	parts := strings.Split(filepath.ToSlash(key), "/")
+	if validateCollectionName(parts[0]) != nil {
+		return false
+	}
+
+	switch len(parts) {
+	case 2:
+		return parts[1] == ".gitkeep"
+	case 3:
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil || strconv.FormatUint(id, 10) != parts[1] {
+			return false
+		}
+		return parts[2] == ".gitkeep" || ValidateName(parts[2]) == nil
+	}
+
+	return false
+}
+
+/*
+ Remove temporary files of interrupted atomic writes
+*/
+func (self *Repository) removeTempFiles(paths []string) error {
+	for _, key := range paths {
+		if !strings.HasPrefix(filepath.Base(key), atomicTempPrefix) {
+			continue
+		}
+
+		err := os.Remove(filepath.Join(self.BasePath, key))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ Restore the paths the repository could have written to
+ HEAD and remove directories left empty by removed
+ documents. Other new files are left untouched.
+*/
+func (self *Repository) resetPaths(paths []string) error {
+	paths, err := self.recoverablePaths(paths)
+	if err != nil {
+		return err
+	}
+
+	if err := self.restoreHead(paths...); err != nil {
+		return err
+	}
+
+	for _, key := range paths {
+		dir := filepath.Dir(key)
+		for dir != "." && dir != string(filepath.Separator) {
+			// Fails if the directory is not empty
+			if os.Remove(filepath.Join(self.BasePath, dir)) != nil {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package gitbase
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/*
+ Make a repository with uncommitted leftovers of a crash,
+ the revision of HEAD is returned.
+*/
+func makeDirtyRepository(t *testing.T, path string) string {
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Fatal("Could not initialize repo:", err)
+	}
+
+	if err := repo.Put("doc", []byte("committed"), "add doc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Put("removed", []byte("removed"), "add removed"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"doc":                           "uncommitted",
+		"new/doc":                       "new",
+		"new/" + atomicTempPrefix + "1": "partial",
+	}
+	for key, content := range files {
+		path := filepath.Join(path, key)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(path, "removed")); err != nil {
+		t.Fatal(err)
+	}
+
+	rev, err := repo.headRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rev
+}
+
+func TestNewRepositoryDirtyWorktreeCommit(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	makeDirtyRepository(t, path)
+
+	// New documents of an archive
+	files := map[string]string{
+		"programs/.gitkeep":   "",
+		"programs/1/.gitkeep": "",
+		"programs/1/new.lua":  "new",
+		"notes.txt":           "notes",
+		"staged.txt":          "staged",
+	}
+	for key, content := range files {
+		path := filepath.Join(path, key)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A file staged outside of the repository
+	gitRepo, err := git.PlainOpen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := gitRepo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add("staged.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	logger := &testLogger{}
+	opts := DefaultRepositoryOptions()
+	opts.DirtyWorktree = DirtyWorktreeCommit
+	opts.Logger = logger
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	history, err := repo.History("programs/1/new.lua")
+	if err != nil || len(history) != 1 {
+		t.Error("Expected recovery commit, got:", history, err)
+		return
+	}
+	if history[0].Message != RecoveryReason {
+		t.Error("Unexpected reason:", history[0].Message)
+	}
+
+	res, _ := repo.Fetch("doc")
+	if string(res) != "uncommitted" {
+		t.Error("Expected recovered document, got:", string(res))
+	}
+	tree, _ := repo.headTree()
+	if _, err := tree.FindEntry("removed"); err == nil {
+		t.Error("Expected removal to be recovered")
+	}
+
+	// Files outside of collections are not committed
+	paths, _ := repo.dirtyPaths()
+	expected := []string{"new/doc", "notes.txt", "staged.txt"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Error("Unexpected dirty paths:", paths)
+	}
+	if logger.find("leaving files outside of collections untouched") == nil {
+		t.Error("Expected skipped files to be logged")
+	}
+
+	// Nor by later writes
+	err = repo.Put("programs/1/new.lua", []byte("update"), "update new")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tree, _ = repo.headTree()
+	for _, key := range expected {
+		if _, err := tree.FindEntry(key); err == nil {
+			t.Error("Expected file not to be committed:", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(path, "new", atomicTempPrefix+"1")); !os.IsNotExist(err) {
+		t.Error("Expected temporary file to be removed")
+	}
+}
+
+func TestNewRepositoryDirtyWorktreeReset(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	rev := makeDirtyRepository(t, path)
+
+	files := map[string]string{
+		"programs/.gitkeep":   "",
+		"programs/1/.gitkeep": "",
+		"programs/1/new.lua":  "new",
+		"README":              "readme",
+	}
+	for key, content := range files {
+		path := filepath.Join(path, key)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := DefaultRepositoryOptions()
+	opts.DirtyWorktree = DirtyWorktreeReset
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if head, _ := repo.headRevision(); head != rev {
+		t.Error("Expected no new commit, got:", head)
+	}
+
+	res, _ := repo.Fetch("doc")
+	if string(res) != "committed" {
+		t.Error("Expected restored document, got:", string(res))
+	}
+	res, _ = repo.Fetch("removed")
+	if string(res) != "removed" {
+		t.Error("Expected restored document, got:", string(res))
+	}
+	if _, err := os.Stat(filepath.Join(path, "programs")); !os.IsNotExist(err) {
+		t.Error("Expected new collection to be removed")
+	}
+
+	// Files outside of collections are kept
+	res, _ = ioutil.ReadFile(filepath.Join(path, "README"))
+	if string(res) != "readme" {
+		t.Error("Expected README to be kept, got:", string(res))
+	}
+	paths, _ := repo.dirtyPaths()
+	expected := []string{"README", "new/doc"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Error("Unexpected dirty paths:", paths)
+	}
+}
+
+func TestNewRepositoryDirtyWorktreeFail(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	makeDirtyRepository(t, path)
+
+	// Failing is the default
+	_, err := NewRepository(path)
+	dirtyErr, ok := err.(*DirtyWorktreeError)
+	if !ok {
+		t.Error("Expected DirtyWorktreeError, got:", err)
+		return
+	}
+
+	expected := []string{"doc", "new/doc", "removed"}
+	if !reflect.DeepEqual(dirtyErr.Paths, expected) {
+		t.Error("Unexpected dirty paths:", dirtyErr.Paths)
+	}
+}
@@ -1,14 +1,17 @@
 package gitbase
 
 import (
+	"golang.org/x/crypto/openpgp"
 	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 
 	"io/ioutil"
 	"path/filepath"
 
+	"bytes"
+	"context"
 	"errors"
-	"log"
 	"os"
 	"sync"
 	"time"
@@ -52,6 +55,7 @@ A gitbase repository consists of
 
 var (
 	ErrRepositoryPathNotEmpty = errors.New("repository path not empty")
+	ErrConflict               = errors.New("document was changed concurrently")
 )
 
 type Repository struct {
@@ -60,7 +64,40 @@ type Repository struct {
 	BasePath string
 	Worktree *git.Worktree
 
+	Author    Identity
+	Committer Identity
+
+	HistoryBackend HistoryBackend
+	FollowRenames  bool
+
+	LockTimeout time.Duration
+
+	SignKey *openpgp.Entity
+	Keyring openpgp.EntityList
+
+	BlobThreshold int64
+	BlobStorePath string
+
+	Encryption map[string]KeyProvider
+
+	Logger Logger
+
 	gitRepo *git.Repository
+	gitDir  string
+
+	// Token of the lock file while the lock is held
+	lockToken string
+
+	// Branch views refer to the repository they were
+	// derived from.
+	root   *Repository
+	branch string
+
+	// Read-only views are bound to a commit
+	commit *object.Commit
+
+	subscriptions subscriptionList
+	hooks         HookRegistry
 }
 
 /*
@@ -91,6 +128,19 @@ func repositoryCanInitialize(path string) error {
  Open and (if needed) initialize repository
 */
 func NewRepository(path string) (*Repository, error) {
+	return NewRepositoryWithOptions(path, DefaultRepositoryOptions())
+}
+
+/*
+ Open and (if needed) initialize repository with options
+*/
+func NewRepositoryWithOptions(
+	path string,
+	options *RepositoryOptions,
+) (*Repository, error) {
+	if options == nil {
+		options = DefaultRepositoryOptions()
+	}
 
 	// Assert path exists
 	err := os.MkdirAll(path, 0755)
@@ -101,7 +151,11 @@ func NewRepository(path string) (*Repository, error) {
 	// Check if we can open this repository
 	gitRepo, err := git.PlainOpen(path)
 	if err != nil {
-		log.Println("Initializing repository:", path)
+		logger := options.Logger
+		if logger == nil {
+			logger = DefaultLogger
+		}
+		logger.Info("initializing repository", "path", path)
 		err = repositoryCanInitialize(path)
 		if err != nil {
 			// Path exists, but we can not initialize
@@ -121,10 +175,42 @@ func NewRepository(path string) (*Repository, error) {
 		return nil, err
 	}
 
+	author := options.Author
+	if author.IsZero() {
+		author = DefaultIdentity
+	}
+
+	committer := options.Committer
+	if committer.IsZero() {
+		committer = author
+	}
+
 	repo := &Repository{
-		BasePath: path,
-		Worktree: worktree,
-		gitRepo:  gitRepo,
+		BasePath:  path,
+		Worktree:  worktree,
+		Author:    author,
+		Committer: committer,
+		gitRepo:   gitRepo,
+		gitDir:    filepath.Join(path, ".git"),
+
+		HistoryBackend: options.HistoryBackend,
+		FollowRenames:  options.FollowRenames,
+		LockTimeout:    options.LockTimeout,
+
+		SignKey: options.SignKey,
+		Keyring: options.Keyring,
+
+		BlobThreshold: options.BlobThreshold,
+		BlobStorePath: options.BlobStorePath,
+
+		Encryption: options.Encryption,
+
+		Logger: options.Logger,
+	}
+
+	// Uncommitted changes are left by a crash
+	if err := repo.reconcileWorktree(options.DirtyWorktree); err != nil {
+		return nil, err
 	}
 
 	return repo, nil
@@ -134,67 +220,208 @@ func NewRepository(path string) (*Repository, error) {
  Stage changes in repository
 */
 func (self *Repository) StageChanges() error {
+	return self.StageChangesContext(context.Background())
+}
+
+func (self *Repository) StageChangesContext(ctx context.Context) error {
+	if self.commit != nil {
+		return ErrReadOnly
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	_, err := self.Worktree.Add(".")
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Adding the worktree does not stage removed files
+	status, err := self.Worktree.Status()
+	if err != nil {
+		return err
+	}
+
+	for path, fileStatus := range status {
+		if fileStatus.Worktree != git.Deleted {
+			continue
+		}
+		if _, err := self.Worktree.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 /*
- Commit a change in the repository
+ Commit a change in the repository.
+ The author is the repository's default author, unless
+ provided by a write option.
 */
-func (self *Repository) Commit(reason string) error {
-	_, err := self.Worktree.Commit(
-		reason, &git.CommitOptions{
-			Author: &object.Signature{
-				Name:  "gitbase",
-				Email: "git@gitbase",
-				When:  time.Now(),
-			},
+func (self *Repository) Commit(reason string, opts ...WriteOption) error {
+	return self.CommitContext(context.Background(), reason, opts...)
+}
+
+func (self *Repository) CommitContext(
+	ctx context.Context, reason string, opts ...WriteOption,
+) error {
+	if self.commit != nil {
+		return ErrReadOnly
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	options := makeWriteOptions(opts)
+	author := options.authorOr(self.Author)
+
+	message, err := formatMessage(reason, options.trailers)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	hash, err := self.Worktree.Commit(
+		message, &git.CommitOptions{
+			Author:    author.Signature(now),
+			Committer: self.Committer.Signature(now),
+			Parents:   options.parents,
+			SignKey:   self.SignKey,
 		})
-	return err
+	if err != nil {
+		return err
+	}
+
+	return self.afterCommit(hash)
 }
 
 /*
  Combined Add + Commit for convenience
 */
-func (self *Repository) CommitAll(reason string) error {
-	if err := self.StageChanges(); err != nil {
+func (self *Repository) CommitAll(reason string, opts ...WriteOption) error {
+	return self.CommitAllContext(context.Background(), reason, opts...)
+}
+
+func (self *Repository) CommitAllContext(
+	ctx context.Context, reason string, opts ...WriteOption,
+) error {
+	if err := self.StageChangesContext(ctx); err != nil {
 		return err
 	}
 
-	return self.Commit(reason)
+	return self.CommitContext(ctx, reason, opts...)
+}
+
+/*
+ Get the tree of the current HEAD commit.
+ If there are no commits yet, the tree is nil.
+*/
+func (self *Repository) headTree() (*object.Tree, error) {
+	commit, err := self.headCommit()
+	if commit == nil || err != nil {
+		return nil, err
+	}
+
+	return commit.Tree()
+}
+
+/*
+ Get the HEAD commit, or the commit of a read-only view.
+ If there are no commits yet, the commit is nil.
+*/
+func (self *Repository) headCommit() (*object.Commit, error) {
+	if self.commit != nil {
+		return self.commit, nil
+	}
+
+	head, err := self.gitRepo.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return self.gitRepo.CommitObject(head.Hash())
+}
+
+/*
+ Get the commit id of HEAD
+*/
+func (self *Repository) headRevision() (string, error) {
+	commit, err := self.headCommit()
+	if err != nil {
+		return "", err
+	}
+	if commit == nil {
+		return "", plumbing.ErrReferenceNotFound
+	}
+
+	return commit.Hash.String(), nil
 }
 
 /*
  Get all collections in the repository
 */
-func (self *Repository) Collections() []*Collection {
+func (self *Repository) Collections() ([]*Collection, error) {
+	return self.CollectionsContext(context.Background())
+}
 
-	return nil
+func (self *Repository) CollectionsContext(
+	ctx context.Context,
+) ([]*Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ListCollections(self)
 }
 
 func (self *Repository) Create(
-	name string, reason string,
+	name string, reason string, opts ...WriteOption,
+) (*Collection, error) {
+	return self.CreateContext(context.Background(), name, reason, opts...)
+}
+
+func (self *Repository) CreateContext(
+	ctx context.Context, name string, reason string, opts ...WriteOption,
 ) (*Collection, error) {
-	return CreateCollection(self, name, reason)
+	return CreateCollectionContext(ctx, self, name, reason, opts...)
 }
 
 func (self *Repository) Open(name string) (*Collection, error) {
+	return self.OpenContext(context.Background(), name)
+}
+
+func (self *Repository) OpenContext(
+	ctx context.Context, name string,
+) (*Collection, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	return OpenCollection(self, name)
 }
 
-func (self *Repository) Use(name string) (*Collection, error) {
+func (self *Repository) Use(
+	name string, opts ...WriteOption,
+) (*Collection, error) {
+	return self.UseContext(context.Background(), name, opts...)
+}
+
+func (self *Repository) UseContext(
+	ctx context.Context, name string, opts ...WriteOption,
+) (*Collection, error) {
 
 	// Try to open collection, if that fails
-	collection, err := self.Open(name)
+	collection, err := self.OpenContext(ctx, name)
 	if err == ErrCollectionDoesNotExist {
 		// Try to create the collection
-		collection, err = self.Create(
-			name, "automatically created collection on use",
+		collection, err = self.CreateContext(ctx,
+			name, "automatically created collection on use", opts...,
 		)
-
-		if err != nil {
-			return nil, err
-		}
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	return collection, nil
@@ -203,26 +430,244 @@ func (self *Repository) Use(name string) (*Collection, error) {
 /*
  Document Storage: Put, adds a document to the repo
 */
-func (self *Repository) Put(key string, document []byte, reason string) error {
-	self.Lock()
-	defer self.Unlock()
+func (self *Repository) Put(
+	key string, document []byte, reason string, opts ...WriteOption,
+) error {
+	return self.PutContext(
+		context.Background(), key, document, reason, opts...)
+}
 
-	path := filepath.Join(self.BasePath, key)
+/*
+ Cancellation of write operations: the context is
+ checked until the repository lock is acquired, after
+ that the change is written and committed.
+*/
+func (self *Repository) PutContext(
+	ctx context.Context,
+	key string,
+	document []byte,
+	reason string,
+	opts ...WriteOption,
+) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	document, err := self.Hooks().runPreWrite(key, document)
+	if err != nil {
+		return err
+	}
+
+	if err := self.lockContext(ctx); err != nil {
+		return err
+	}
+	defer self.unlock()
 
-	err := ioutil.WriteFile(path, document, 0644)
+	err = self.writeDocument(key, document)
 	if err != nil {
 		return err
 	}
 
 	// Commit to repository
-	err = self.CommitAll(reason)
-	return err
+	return self.commitOrRestore(reason, []string{key}, opts...)
+}
+
+/*
+ Conditional Put: The document is only written if its
+ latest revision matches the expected revision. Use an
+ empty expected revision if the document must not exist.
+ The expected revision may be any revision accepted by
+ FetchRevision, like a short hash, and is resolved to
+ its commit before comparing.
+
+ On success the new revision is returned. If the revision
+ does not match, ErrConflict and the current revision
+ of the document is returned.
+*/
+func (self *Repository) PutIfRevision(
+	key string,
+	document []byte,
+	expectedRev string,
+	reason string,
+	opts ...WriteOption,
+) (string, error) {
+	return self.PutIfRevisionContext(
+		context.Background(), key, document, expectedRev, reason, opts...)
+}
+
+func (self *Repository) PutIfRevisionContext(
+	ctx context.Context,
+	key string,
+	document []byte,
+	expectedRev string,
+	reason string,
+	opts ...WriteOption,
+) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+
+	document, err := self.Hooks().runPreWrite(key, document)
+	if err != nil {
+		return "", err
+	}
+
+	if err := self.lockContext(ctx); err != nil {
+		return "", err
+	}
+	defer self.unlock()
+
+	currentRev, err := self.currentRevision(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	// Any revision accepted by FetchRevision may be expected
+	if expectedRev != "" {
+		commit, err := self.resolveRevision(expectedRev)
+		if err == ErrRevisionNotFound {
+			return currentRev, ErrConflict
+		}
+		if err != nil {
+			return "", err
+		}
+		expectedRev = commit.Hash.String()
+	}
+
+	if currentRev != expectedRev {
+		return currentRev, ErrConflict
+	}
+
+	err = self.writeDocument(key, document)
+	if err != nil {
+		return "", err
+	}
+
+	commitErr := self.commitOrRestore(reason, []string{key}, opts...)
+	if commitErr != nil && !isCommitted(commitErr) {
+		return "", commitErr
+	}
+
+	rev, err := self.headRevision()
+	if err != nil {
+		return "", err
+	}
+
+	return rev, commitErr
+}
+
+/*
+ Get the latest revision of a document. If the document
+ does not exist at HEAD, the revision is empty.
+*/
+func (self *Repository) currentRevision(
+	ctx context.Context, key string,
+) (string, error) {
+	tree, err := self.headTree()
+	if err != nil || tree == nil {
+		return "", err
+	}
+
+	_, err = tree.FindEntry(filepath.ToSlash(key))
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	revisions, err := self.RevisionsContext(ctx, key)
+	if err != nil || len(revisions) == 0 {
+		return "", err
+	}
+
+	return revisions[0], nil
+}
+
+/*
+ Write the document to the worktree without committing.
+ The document is encrypted or escaped and offloaded
+ if required. The caller must hold the repository lock.
+*/
+func (self *Repository) writeDocument(key string, document []byte) error {
+	if self.keyProvider(key) == nil {
+		document = escapeDocument(document)
+	}
+
+	document, err := self.encryptDocument(key, document)
+	if err != nil {
+		return err
+	}
+
+	document, err = self.offloadDocument(document)
+	if err != nil {
+		return err
+	}
+
+	return self.writeStoredDocument(key, document)
+}
+
+/*
+ Write the document as it is stored in the repository,
+ e.g. when restoring it from a commit.
+ The caller must hold the repository lock.
+*/
+func (self *Repository) writeStoredDocument(key string, document []byte) error {
+	path := filepath.Join(self.BasePath, key)
+	return writeFileAtomic(path, bytes.NewReader(document))
+}
+
+/*
+ Read the document from the worktree as it is stored
+*/
+func (self *Repository) readStoredDocument(key string) ([]byte, error) {
+	path := filepath.Join(self.BasePath, key)
+	return ioutil.ReadFile(path)
+}
+
+/*
+ Resolve pointers and decrypt or unescape a stored document
+*/
+func (self *Repository) decodeDocument(
+	key string, document []byte,
+) ([]byte, error) {
+	document, err := self.resolveDocument(document)
+	if err != nil {
+		return nil, err
+	}
+
+	if document, escaped := unescapeDocument(document); escaped {
+		return document, nil
+	}
+
+	return self.decryptDocument(key, document)
 }
 
 /*
 Fetch a single document
 */
 func (self *Repository) Fetch(key string) ([]byte, error) {
+	return self.FetchContext(context.Background(), key)
+}
+
+func (self *Repository) FetchContext(
+	ctx context.Context, key string,
+) ([]byte, error) {
+	if err := ValidateKey(key); err != nil {
+		return []byte{}, err
+	}
+	if err := ctx.Err(); err != nil {
+		return []byte{}, err
+	}
+
+	if self.commit != nil {
+		document, err := self.readViewDocument(key)
+		if err != nil {
+			return nil, err
+		}
+		return self.decodeDocument(key, document)
+	}
+
 	path := filepath.Join(self.BasePath, key)
 	file, err := os.Open(path)
 	if err != nil {
@@ -231,48 +676,92 @@ func (self *Repository) Fetch(key string) ([]byte, error) {
 	defer file.Close()
 
 	document, err := ioutil.ReadAll(file)
-	return document, err
+	if err != nil {
+		return document, err
+	}
+
+	return self.decodeDocument(key, document)
 }
 
 /*
- Fetch a specific version of this document
+ Fetch a specific version of this document.
+ The revision may be any commit-ish revision, like
+ a hash, a short hash, HEAD~2, a branch or a tag.
 */
 func (self *Repository) FetchRevision(key, rev string) ([]byte, error) {
-	// This is a bit hackish because we are falling
-	// back to the git cli, as this is not (yet) implemented
-	// in go-git. At least as far I could see.
-	// Maybe add this.
-	return GitShow(self.BasePath, key, rev)
+	return self.FetchRevisionContext(context.Background(), key, rev)
+}
+
+func (self *Repository) FetchRevisionContext(
+	ctx context.Context, key, rev string,
+) ([]byte, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	document, err := self.readRevision(key, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	return self.decodeDocument(key, document)
 }
 
 /*
 Remove a document
 */
-func (self *Repository) Remove(key string, reason string) error {
-	// Derive path
-	path := filepath.Join(self.BasePath, key)
+func (self *Repository) Remove(
+	key string, reason string, opts ...WriteOption,
+) error {
+	return self.RemoveContext(context.Background(), key, reason, opts...)
+}
+
+func (self *Repository) RemoveContext(
+	ctx context.Context, key string, reason string, opts ...WriteOption,
+) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
 
-	self.Lock()
-	defer self.Unlock()
+	if err := self.lockContext(ctx); err != nil {
+		return err
+	}
+	defer self.unlock()
 
 	// Remove from filesystem
-	err := os.Remove(path)
-
+	err := self.removeDocument(key)
 	if err != nil {
 		return err
 	}
 
 	// Commit change
-	err = self.CommitAll(reason)
-	return err
+	return self.commitOrRestore(reason, []string{key}, opts...)
+}
+
+/*
+ Remove the document from the worktree without committing.
+ The caller must hold the repository lock.
+*/
+func (self *Repository) removeDocument(key string) error {
+	path := filepath.Join(self.BasePath, key)
+	return os.Remove(path)
 }
 
 /*
 List versions of a given document
 */
 func (self *Repository) Revisions(key string) ([]string, error) {
+	return self.RevisionsContext(context.Background(), key)
+}
+
+func (self *Repository) RevisionsContext(
+	ctx context.Context, key string,
+) ([]string, error) {
 	revisions := []string{}
-	history, err := self.History(key)
+	history, err := self.HistoryContext(ctx, key)
 	if err != nil {
 		return revisions, err
 	}
@@ -285,10 +774,42 @@ func (self *Repository) Revisions(key string) ([]string, error) {
 }
 
 /*
-Get commit history
+Get commit history. Use "." as key for the history
+of the entire repository.
 */
 func (self *Repository) History(key string) ([]*Commit, error) {
-	// Again, this is a bit hackish because we are falling
-	// back to the git cli, as go-git does not support git log --follow
-	return GitHistory(self.BasePath, key)
+	return self.HistoryContext(context.Background(), key)
+}
+
+/*
+ Get the commit history, a running git process
+ is killed when the context is done.
+*/
+func (self *Repository) HistoryContext(
+	ctx context.Context, key string,
+) ([]*Commit, error) {
+	if key != "." {
+		if err := ValidateKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		commits []*Commit
+		err     error
+	)
+
+	// Views always use the native history
+	if self.HistoryBackend == HistoryGitCLI && self.root == nil {
+		commits, err = gitHistory(
+			ctx, self.logger(), self.BasePath, key, self.FollowRenames)
+	} else {
+		commits, err = self.nativeHistory(ctx, key, self.FollowRenames)
+	}
+	if err != nil {
+		return commits, err
+	}
+
+	err = self.verifyCommits(commits)
+	return commits, err
 }
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -157,3 +158,60 @@ func TestRepositoryDocumentStorage(t *testing.T) {
 		t.Error("Expected fetch(hello.doc) to fail after removal!")
 	}
 }
+
+func TestRepositoryCommitIdentity(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path)
+
+	repo, err := NewRepositoryWithOptions(path, &RepositoryOptions{
+		Author: Identity{
+			Name:  "Importer Service",
+			Email: "importer@example.com",
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = repo.Put("hello.doc", []byte("Hello"), "added test document")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = repo.Put(
+		"hello.doc", []byte("Hello World!"), "updated test document",
+		WithAuthor("Jane Doe", "jane@example.com"),
+	)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	history, err := repo.History("hello.doc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(history) != 2 {
+		t.Error("Expected 2 commits, got:", len(history))
+		return
+	}
+
+	if !strings.HasPrefix(history[0].Author, "Jane Doe <jane@example.com>") {
+		t.Error("Unexpected author:", history[0].Author)
+	}
+	if !strings.HasPrefix(
+		history[0].Committer,
+		"Importer Service <importer@example.com>",
+	) {
+		t.Error("Unexpected committer:", history[0].Committer)
+	}
+	if !strings.HasPrefix(
+		history[1].Author,
+		"Importer Service <importer@example.com>",
+	) {
+		t.Error("Unexpected author:", history[1].Author)
+	}
+}
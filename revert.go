@@ -0,0 +1,242 @@
+package gitbase
+
+/*
+Revert documents to an earlier revision.
+
+A revert creates a single commit, the commit message
+references the revision the documents were restored from.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/*
+ Restore a document as it was in a revision. If the
+ document did not exist in the revision, it is removed.
+ If the archive did not exist in the revision,
+ ErrArchiveDoesNotExist is returned.
+*/
+func (self *Archive) Revert(
+	key, rev string, reason string, opts ...WriteOption,
+) error {
+	return self.RevertContext(context.Background(), key, rev, reason, opts...)
+}
+
+func (self *Archive) RevertContext(
+	ctx context.Context, key, rev string, reason string, opts ...WriteOption,
+) error {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return err
+	}
+
+	repo := self.Collection.Repository
+	commit, err := repo.resolveRevision(rev)
+	if err != nil {
+		return err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	// The archive must exist in the revision
+	_, err = tree.Tree(self.Key(""))
+	if err == object.ErrDirectoryNotFound {
+		return ErrArchiveDoesNotExist
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := repo.lockContext(ctx); err != nil {
+		return err
+	}
+	defer repo.unlock()
+
+	// Restore the archive, if it was destroyed
+	keys, err := self.restorePath()
+	if err != nil {
+		return err
+	}
+
+	document, err := readTreeDocument(tree, path)
+	if err == ErrDocumentNotFound {
+		err = repo.removeDocument(path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else if err == nil {
+		err = repo.writeStoredDocument(path, document)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Fall back to default reason if required
+	if reason == "" {
+		reason = "reverted " + path
+	}
+
+	keys = append(keys, filepath.FromSlash(path))
+	return repo.commitRevert(reason, commit.Hash.String(), keys, opts...)
+}
+
+/*
+ Restore all documents of the archive as they were in
+ a revision. Documents added afterwards are removed.
+*/
+func (self *Archive) RevertAll(
+	rev string, reason string, opts ...WriteOption,
+) error {
+	return self.RevertAllContext(context.Background(), rev, reason, opts...)
+}
+
+func (self *Archive) RevertAllContext(
+	ctx context.Context, rev string, reason string, opts ...WriteOption,
+) error {
+	repo := self.Collection.Repository
+	commit, err := repo.resolveRevision(rev)
+	if err != nil {
+		return err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	// Documents in the revision, the archive
+	// must exist in the revision
+	documents, err := self.DocumentsRevisionContext(ctx, commit.Hash.String())
+	if err != nil {
+		return err
+	}
+
+	if err := repo.lockContext(ctx); err != nil {
+		return err
+	}
+	defer repo.unlock()
+
+	// Restore the archive, if it was destroyed
+	keys, err := self.restorePath()
+	if err != nil {
+		return err
+	}
+
+	// Remove documents added afterwards
+	current, err := self.Documents()
+	if err != nil {
+		return err
+	}
+
+	restore := map[string]bool{}
+	for _, key := range documents {
+		restore[key] = true
+	}
+
+	// All touched documents are restored if the commit fails
+	for _, key := range documents {
+		keys = append(keys, filepath.FromSlash(self.Key(key)))
+	}
+	for _, key := range current {
+		if !restore[key] {
+			keys = append(keys, filepath.FromSlash(self.Key(key)))
+		}
+	}
+
+	for _, key := range current {
+		if restore[key] {
+			continue
+		}
+		if err := repo.removeDocument(self.Key(key)); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range documents {
+		document, err := readTreeDocument(tree, self.Key(key))
+		if err != nil {
+			return err
+		}
+		if err := repo.writeStoredDocument(self.Key(key), document); err != nil {
+			return err
+		}
+	}
+
+	// Fall back to default reason if required
+	if reason == "" {
+		reason = fmt.Sprintf("reverted archive id: %d", self.Id)
+	}
+
+	return repo.commitRevert(reason, commit.Hash.String(), keys, opts...)
+}
+
+/*
+ Commit a revert, if anything changed. The reverted
+ documents are restored to HEAD if the commit fails.
+ The caller must hold the repository lock.
+*/
+func (self *Repository) commitRevert(
+	reason, rev string, keys []string, opts ...WriteOption,
+) error {
+	if err := self.stageDocuments(keys...); err != nil {
+		return err
+	}
+
+	status, err := self.Worktree.Status()
+	if err != nil {
+		return err
+	}
+
+	// Other changes in the worktree are not committed
+	changed := false
+	for _, key := range keys {
+		fileStatus, ok := status[filepath.ToSlash(key)]
+		if ok && fileStatus.Staging != git.Unmodified &&
+			fileStatus.Staging != git.Untracked {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	message := reason + "\n\nReverted to revision " + rev
+	return self.commitOrRestore(message, keys, opts...)
+}
+
+/*
+ Restore the collection and the archive, if they were
+ destroyed. The keys of their .gitkeep files are returned.
+ The caller must hold the repository lock.
+*/
+func (self *Archive) restorePath() ([]string, error) {
+	collection := self.Collection
+	keys := []string{
+		filepath.Join(collection.Name, ".gitkeep"),
+		filepath.FromSlash(self.Key(".gitkeep")),
+	}
+
+	_, err := os.Stat(filepath.Join(collection.Path(), ".gitkeep"))
+	if os.IsNotExist(err) {
+		err = collection.createPath()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createArchivePath(collection, self.Id); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,204 @@
+package gitbase
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestArchiveRevert(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	archive.Put("source.lua", []byte("x = 1"), "add source")
+	archive.Put("config.lua", []byte("y = 1"), "add config")
+
+	rev, err := repo.headRevision()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	archive.Put("source.lua", []byte("x = 2"), "update source")
+	archive.Remove("config.lua", "remove config")
+	archive.Put("extra.lua", []byte("z = 1"), "add extra")
+
+	// Revert a single document
+	if err := archive.Revert("source.lua", rev, "restore source"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	res, err := archive.Fetch("source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "x = 1" {
+		t.Error("Expected x = 1, got:", string(res))
+	}
+
+	history, err := archive.History("source.lua")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.HasPrefix(history[0].Message, "restore source") ||
+		!strings.Contains(history[0].Message, rev) {
+		t.Error("Expected revert message referencing", rev,
+			"got:", history[0].Message)
+	}
+
+	// Re-create a deleted document
+	if err := archive.Revert("config.lua", rev, ""); err != nil {
+		t.Error(err)
+	}
+	if _, err := archive.Fetch("config.lua"); err != nil {
+		t.Error("Expected config.lua to be restored:", err)
+	}
+
+	// Remove a document added later
+	if err := archive.Revert("extra.lua", rev, ""); err != nil {
+		t.Error(err)
+	}
+	if _, err := archive.Fetch("extra.lua"); err == nil {
+		t.Error("Expected extra.lua to be removed")
+	}
+
+	// Archives not in the revision are not created
+	head, _ := repo.headRevision()
+	missing := &Archive{Id: 99, Collection: programs}
+	if err := missing.Revert("x.lua", "HEAD", ""); err != ErrArchiveDoesNotExist {
+		t.Error("Expected ErrArchiveDoesNotExist, got:", err)
+	}
+	if err := missing.RevertAll("HEAD", ""); err != ErrArchiveDoesNotExist {
+		t.Error("Expected ErrArchiveDoesNotExist, got:", err)
+	}
+	if _, err := os.Stat(missing.Path()); !os.IsNotExist(err) {
+		t.Error("Expected archive not to be created")
+	}
+	if rev, _ := repo.headRevision(); rev != head {
+		t.Error("Expected no commit, got:", rev)
+	}
+}
+
+func TestArchiveRevertAll(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	archive.Put("source.lua", []byte("x = 1"), "add source")
+	archive.Put("config.lua", []byte("y = 1"), "add config")
+
+	rev, err := repo.headRevision()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	archive.Put("source.lua", []byte("x = 2"), "update source")
+	archive.Remove("config.lua", "remove config")
+	archive.Put("extra.lua", []byte("z = 1"), "add extra")
+
+	before, _ := repo.History(".")
+
+	if err := archive.RevertAll(rev, "restore program"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	after, _ := repo.History(".")
+	if len(after) != len(before)+1 {
+		t.Error("Expected a single revert commit")
+	}
+
+	expected := map[string]string{
+		"source.lua": "x = 1",
+		"config.lua": "y = 1",
+	}
+	documents, err := archive.Documents()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(documents) != len(expected) {
+		t.Error("Expected documents:", expected, "got:", documents)
+	}
+	for key, content := range expected {
+		res, err := archive.Fetch(key)
+		if err != nil {
+			t.Error(err)
+		}
+		if string(res) != content {
+			t.Error("Expected:", content, "got:", string(res))
+		}
+	}
+
+	// Nothing left to revert
+	if err := archive.RevertAll(rev, ""); err != nil {
+		t.Error(err)
+	}
+	unchanged, _ := repo.History(".")
+	if len(unchanged) != len(after) {
+		t.Error("Expected no commit without changes")
+	}
+
+	// Restore a destroyed collection
+	for _, revert := range []func() error{
+		func() error { return archive.RevertAll(rev, "") },
+		func() error { return archive.Revert("source.lua", rev, "") },
+	} {
+		if err := programs.Destroy(""); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := revert(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		res, _ := archive.Fetch("source.lua")
+		if string(res) != "x = 1" {
+			t.Error("Expected restored document, got:", string(res))
+		}
+		report, err := repo.Verify()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !report.OK() {
+			t.Error("Expected no issues, got:", report.Issues)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package gitbase
+
+/*
+Revision supplemental:
+Resolve revisions and read documents from the
+git object storage using go-git.
+
+A revision may be anything accepted by git rev-parse
+for commits, e.g.
+
+  HEAD, HEAD~3, HEAD^2, master, v1.0, d7585cb, d7585cb~1
+
+Revisions selecting paths or searching commit messages
+(HEAD:path, :/message) and reflog selectors (master@{1})
+are not supported.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrInvalidRevision   = errors.New("invalid revision")
+	ErrAmbiguousRevision = errors.New("short revision is ambiguous")
+	ErrDocumentNotFound  = errors.New("document not found in revision")
+)
+
+const minShortHashLen = 4
+
+/*
+ Resolve a revision to a commit
+*/
+func (self *Repository) resolveRevision(rev string) (*object.Commit, error) {
+	if err := validateRevision(rev); err != nil {
+		return nil, err
+	}
+
+	rev, err := self.expandShortHash(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := self.parseRevision(rev)
+	if err == plumbing.ErrReferenceNotFound ||
+		err == plumbing.ErrObjectNotFound ||
+		err == io.EOF { // Walked past the first commit
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		// The parser error type is internal to go-git
+		if strings.HasPrefix(err.Error(), "Revision invalid") {
+			return nil, ErrInvalidRevision
+		}
+		return nil, err
+	}
+
+	commit, err := self.gitRepo.CommitObject(*hash)
+	if err == plumbing.ErrObjectNotFound {
+		return nil, ErrRevisionNotFound
+	}
+
+	return commit, err
+}
+
+/*
+ Reject revisions the go-git parser does not handle:
+ An empty revision and path or message search
+ selectors make it panic, reflog selectors are not
+ supported and an unterminated selector makes it hang.
+*/
+func validateRevision(rev string) error {
+	if rev == "" ||
+		strings.Contains(rev, ":") ||
+		strings.Contains(rev, "@{") {
+		return ErrInvalidRevision
+	}
+	return nil
+}
+
+/*
+ Resolve a revision with go-git. The parser panics on
+ some malformed revisions, which are reported
+ as ErrInvalidRevision.
+*/
+func (self *Repository) parseRevision(
+	rev string,
+) (hash *plumbing.Hash, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			hash, err = nil, ErrInvalidRevision
+		}
+	}()
+
+	return self.gitRepo.ResolveRevision(plumbing.Revision(rev))
+}
+
+/*
+ go-git does not resolve abbreviated hashes. If the
+ revision starts with a short hash, which is not a
+ reference name, replace it with the full hash.
+*/
+func (self *Repository) expandShortHash(rev string) (string, error) {
+	end := strings.IndexAny(rev, "~^@:")
+	if end < 0 {
+		end = len(rev)
+	}
+	prefix := rev[:end]
+
+	if len(prefix) < minShortHashLen ||
+		len(prefix) >= 40 ||
+		!parseGitIsHash(prefix) {
+		return rev, nil
+	}
+
+	// References take precedence, like with git
+	for _, rule := range append([]string{"%s"}, plumbing.RefRevParseRules...) {
+		name := plumbing.ReferenceName(strings.Replace(rule, "%s", prefix, 1))
+		if _, err := self.gitRepo.Reference(name, true); err == nil {
+			return rev, nil
+		}
+	}
+
+	commits, err := self.gitRepo.CommitObjects()
+	if err != nil {
+		return rev, err
+	}
+	defer commits.Close()
+
+	match := ""
+	err = commits.ForEach(func(commit *object.Commit) error {
+		hash := commit.Hash.String()
+		if !strings.HasPrefix(hash, prefix) {
+			return nil
+		}
+		if match != "" && match != hash {
+			return ErrAmbiguousRevision
+		}
+		match = hash
+		return nil
+	})
+	if err != nil {
+		return rev, err
+	}
+
+	if match == "" {
+		return rev, ErrRevisionNotFound
+	}
+
+	return match + rev[end:], nil
+}
+
+/*
+ Read a document from the tree of a revision
+*/
+func (self *Repository) readRevision(key, rev string) ([]byte, error) {
+	commit, err := self.resolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	return readTreeDocument(tree, key)
+}
+
+/*
+ Read a document from a tree
+*/
+func readTreeDocument(tree *object.Tree, key string) ([]byte, error) {
+	reader, err := openTreeDocument(tree, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+/*
+ Open a document in a tree for streaming
+ from the object storage
+*/
+func openTreeDocument(tree *object.Tree, key string) (io.ReadCloser, error) {
+	file, err := tree.File(filepath.ToSlash(filepath.Clean(key)))
+	if err == object.ErrFileNotFound ||
+		err == object.ErrDirectoryNotFound ||
+		err == object.ErrEntryNotFound {
+		return nil, ErrDocumentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return file.Reader()
+}
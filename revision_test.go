@@ -0,0 +1,105 @@
+package gitbase
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	"os"
+	"testing"
+)
+
+func TestFetchRevisionSyntax(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	versions := []string{"v1", "v2", "v3", "v4"}
+	for _, v := range versions {
+		if err := repo.Put("test.doc", []byte(v), "update "+v); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	revs, err := repo.Revisions("test.doc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Tag the second version
+	if _, err := repo.gitRepo.CreateTag(
+		"release-1", plumbing.NewHash(revs[2]), nil,
+	); err != nil {
+		t.Error(err)
+		return
+	}
+
+	tests := map[string]string{
+		"HEAD":             "v4",
+		"HEAD~1":           "v3",
+		"HEAD^^^":          "v1",
+		"master":           "v4",
+		"master~2":         "v2",
+		"release-1":        "v2",
+		revs[1]:            "v3",
+		revs[1][:7]:        "v3",
+		revs[1][:7] + "~1": "v2",
+	}
+
+	for rev, expected := range tests {
+		res, err := repo.FetchRevision("test.doc", rev)
+		if err != nil {
+			t.Error(rev, err)
+			continue
+		}
+		if string(res) != expected {
+			t.Error(rev, "Expected:", expected, "got:", string(res))
+		}
+	}
+
+	// Errors
+	_, err = repo.FetchRevision("test.doc", "d3adb33f")
+	if err != ErrRevisionNotFound {
+		t.Error("Expected ErrRevisionNotFound, got:", err)
+	}
+
+	_, err = repo.FetchRevision("test.doc", "HEAD~23")
+	if err != ErrRevisionNotFound {
+		t.Error("Expected ErrRevisionNotFound, got:", err)
+	}
+
+	_, err = repo.FetchRevision("test.doc", "HEAD~~x{")
+	if err != ErrInvalidRevision {
+		t.Error("Expected ErrInvalidRevision, got:", err)
+	}
+
+	invalid := []string{
+		"", ":", ":/v2", ":/nomatch", "HEAD:test.doc", "master@{1}", "@{-1",
+	}
+	for _, rev := range invalid {
+		if _, err := repo.FetchRevision("test.doc", rev); err != ErrInvalidRevision {
+			t.Error(rev, "Expected ErrInvalidRevision, got:", err)
+		}
+		if _, err := repo.At(rev); err != ErrInvalidRevision {
+			t.Error(rev, "Expected ErrInvalidRevision from At, got:", err)
+		}
+		if _, err := repo.Diff("test.doc", rev, "HEAD"); err != ErrInvalidRevision {
+			t.Error(rev, "Expected ErrInvalidRevision from Diff, got:", err)
+		}
+	}
+
+	// Panics of the parser are recovered
+	if _, err := repo.parseRevision(":/v2"); err != ErrInvalidRevision {
+		t.Error("Expected ErrInvalidRevision, got:", err)
+	}
+
+	_, err = repo.FetchRevision("test.dog", "HEAD")
+	if err != ErrDocumentNotFound {
+		t.Error("Expected ErrDocumentNotFound, got:", err)
+	}
+}
@@ -0,0 +1,174 @@
+package gitbase
+
+/*
+Signed commits:
+If a signing key is configured, every commit and snapshot
+is signed with the OpenPGP key. Commits in the history
+are verified against the configured keyring.
+
+Keys are read from files on disk, armored or binary:
+
+    key, err := ReadSignKey("/etc/gitbase/signing.asc", passphrase)
+    keyring, err := ReadKeyring("/etc/gitbase/keyring.asc")
+
+    opts := DefaultRepositoryOptions()
+    opts.SignKey = key
+    opts.Keyring = keyring
+
+    repo, err := NewRepositoryWithOptions(path, opts)
+
+*/
+
+import (
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	ErrNoSignKey = errors.New("no private signing key found")
+)
+
+type VerificationStatus int
+
+const (
+	// The commit is not signed
+	VerificationUnsigned VerificationStatus = iota
+
+	// The commit is signed, but no keyring is configured
+	VerificationUnchecked
+
+	// The signature is valid and the key is in the keyring
+	VerificationGood
+
+	// The commit was signed with a key not in the keyring
+	VerificationUnknownKey
+
+	// The signature does not match the commit
+	VerificationBad
+)
+
+func (self VerificationStatus) String() string {
+	switch self {
+	case VerificationUnsigned:
+		return "unsigned"
+	case VerificationUnchecked:
+		return "unchecked"
+	case VerificationGood:
+		return "good"
+	case VerificationUnknownKey:
+		return "unknown_key"
+	case VerificationBad:
+		return "bad"
+	}
+	return "unknown"
+}
+
+/*
+ Read an armored or binary keyring from a file
+*/
+func ReadKeyring(filename string) (openpgp.EntityList, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+/*
+ Read the first private key from a file. If the key is
+ encrypted, it is decrypted with the passphrase.
+*/
+func ReadSignKey(filename string, passphrase []byte) (*openpgp.Entity, error) {
+	keyring, err := ReadKeyring(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entity := range keyring {
+		if entity.PrivateKey == nil {
+			continue
+		}
+
+		if entity.PrivateKey.Encrypted {
+			if err := entity.PrivateKey.Decrypt(passphrase); err != nil {
+				return nil, err
+			}
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				if err := subkey.PrivateKey.Decrypt(passphrase); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return entity, nil
+	}
+
+	return nil, ErrNoSignKey
+}
+
+/*
+ Verify the signature of a commit against the keyring.
+ The key id of the signer is returned if the
+ signature could be checked.
+*/
+func (self *Repository) verifyCommit(
+	commit *object.Commit,
+) (VerificationStatus, string) {
+	if commit.PGPSignature == "" {
+		return VerificationUnsigned, ""
+	}
+	if len(self.Keyring) == 0 {
+		return VerificationUnchecked, ""
+	}
+
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return VerificationBad, ""
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return VerificationBad, ""
+	}
+
+	signer, err := openpgp.CheckArmoredDetachedSignature(
+		self.Keyring, reader, strings.NewReader(commit.PGPSignature))
+	if err == pgperrors.ErrUnknownIssuer {
+		return VerificationUnknownKey, ""
+	}
+	if err != nil {
+		return VerificationBad, ""
+	}
+
+	return VerificationGood, fmt.Sprintf("%016X", signer.PrimaryKey.KeyId)
+}
+
+/*
+ Set the verification status of commits
+*/
+func (self *Repository) verifyCommits(commits []*Commit) error {
+	for _, commit := range commits {
+		obj, err := self.gitRepo.CommitObject(plumbing.NewHash(commit.Id))
+		if err != nil {
+			return err
+		}
+
+		commit.Verification, commit.SignerKeyId = self.verifyCommit(obj)
+	}
+
+	return nil
+}
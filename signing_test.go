@@ -0,0 +1,186 @@
+package gitbase
+
+import (
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestKey(t *testing.T, filename string, private bool) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("gitbase", "test", "git@gitbase", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+
+	w, err := armor.Encode(f, blockType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if private {
+		err = entity.SerializePrivate(w, nil)
+	} else {
+		err = entity.Serialize(w)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	return entity
+}
+
+func TestRepositorySignedCommits(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	keyPath, err := ioutil.TempDir("", "gitbase-test-keys")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(keyPath)
+
+	signKeyFile := filepath.Join(keyPath, "signing.asc")
+	writeTestKey(t, signKeyFile, true)
+
+	signKey, err := ReadSignKey(signKeyFile, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The public key of the signing key
+	keyringFile := filepath.Join(keyPath, "keyring.asc")
+	f, _ := os.Create(keyringFile)
+	w, _ := armor.Encode(f, openpgp.PublicKeyType, nil)
+	signKey.Serialize(w)
+	w.Close()
+	f.Close()
+
+	keyring, err := ReadKeyring(keyringFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	opts := DefaultRepositoryOptions()
+	opts.SignKey = signKey
+	opts.Keyring = keyring
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	if err := repo.Put("doc", []byte("signed"), "signed put"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	history, err := repo.History("doc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(history) != 1 {
+		t.Error("Expected 1 commit, got:", len(history))
+		return
+	}
+	if history[0].Verification != VerificationGood {
+		t.Error("Expected good signature, got:", history[0].Verification)
+	}
+	if history[0].SignerKeyId == "" {
+		t.Error("Expected signer key id")
+	}
+
+	// Commits by other keys are not trusted
+	otherKeyFile := filepath.Join(keyPath, "other.asc")
+	writeTestKey(t, otherKeyFile, true)
+	otherKey, err := ReadSignKey(otherKeyFile, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	repo.SignKey = otherKey
+	repo.Put("doc", []byte("other"), "signed by other key")
+
+	repo.SignKey = nil
+	repo.Put("doc", []byte("unsigned"), "unsigned put")
+
+	history, err = repo.History("doc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []VerificationStatus{
+		VerificationUnsigned,
+		VerificationUnknownKey,
+		VerificationGood,
+	}
+	for i, commit := range history {
+		if commit.Verification != expected[i] {
+			t.Error("Expected", expected[i], "got:", commit.Verification)
+		}
+	}
+
+	// Without keyring signatures are not checked
+	repo.Keyring = nil
+	history, _ = repo.History("doc")
+	if history[2].Verification != VerificationUnchecked {
+		t.Error("Expected unchecked signature, got:", history[2].Verification)
+	}
+
+	// Keys without private key can not be used for signing
+	publicKeyFile := filepath.Join(keyPath, "public.asc")
+	writeTestKey(t, publicKeyFile, false)
+	if _, err := ReadSignKey(publicKeyFile, nil); err != ErrNoSignKey {
+		t.Error("Expected ErrNoSignKey, got:", err)
+	}
+}
+
+func TestParseGitLogSigned(t *testing.T) {
+	data := []byte("commit 6f3fd6ab5b2c9d2a4bc46d2e2fb6d3c05f4a3c61\n" +
+		"tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"author gitbase <git@gitbase> 1550000000 +0000\n" +
+		"committer gitbase <git@gitbase> 1550000000 +0000\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+		" \n" +
+		" wsBcBAABCAAQBQJcY9cACRDHMEWZ+M6pEQAAAAA=\n" +
+		" -----END PGP SIGNATURE-----\n" +
+		"\n" +
+		"    signed commit\n")
+
+	commits, err := parseGitLog(DefaultLogger, data, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(commits) != 1 {
+		t.Error("Expected 1 commit, got:", len(commits))
+		return
+	}
+	if commits[0].Message != "signed commit" {
+		t.Error("Unexpected message:", commits[0].Message)
+	}
+	if commits[0].Committer != "gitbase <git@gitbase> 1550000000 +0000" {
+		t.Error("Unexpected committer:", commits[0].Committer)
+	}
+}
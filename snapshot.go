@@ -0,0 +1,183 @@
+package gitbase
+
+/*
+Snapshots are named states of the repository, backed
+by annotated git tags.
+
+The name of a snapshot can be used as revision, e.g.
+
+    source, err := archive.FetchRevision("source.lua", "release-42")
+
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name")
+	ErrSnapshotExists      = errors.New("snapshot already exists")
+)
+
+type Snapshot struct {
+	Name    string
+	Message string
+	Tagger  string
+
+	CreatedAt time.Time
+
+	Commit *Commit
+}
+
+/*
+ Snapshot and branch names must be valid names and
+ valid git references, see git check-ref-format.
+*/
+func isValidRefName(name string) bool {
+	if ValidateName(name) != nil ||
+		strings.HasPrefix(name, ".") ||
+		strings.HasPrefix(name, "-") ||
+		strings.HasSuffix(name, ".lock") ||
+		strings.Contains(name, "..") ||
+		strings.Contains(name, "@{") ||
+		name == "@" ||
+		strings.ContainsAny(name, " ~^:?*[") {
+		return false
+	}
+
+	return true
+}
+
+/*
+ Create a snapshot of HEAD
+*/
+func (self *Repository) Snapshot(
+	name string, reason string, opts ...WriteOption,
+) (*Snapshot, error) {
+	return self.SnapshotContext(context.Background(), name, reason, opts...)
+}
+
+func (self *Repository) SnapshotContext(
+	ctx context.Context, name string, reason string, opts ...WriteOption,
+) (*Snapshot, error) {
+	if !isValidRefName(name) {
+		return nil, ErrInvalidSnapshotName
+	}
+
+	// Fall back to default reason if required
+	if reason == "" {
+		reason = "snapshot " + name
+	}
+
+	if err := self.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer self.unlock()
+
+	head, err := self.gitRepo.Head()
+	if err != nil {
+		return nil, err
+	}
+
+	options := makeWriteOptions(opts)
+	tagger := options.authorOr(self.Author)
+
+	message, err := formatMessage(reason, options.trailers)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := self.gitRepo.CreateTag(name, head.Hash(), &git.CreateTagOptions{
+		Tagger:  tagger.Signature(time.Now()),
+		Message: message,
+		SignKey: self.SignKey,
+	})
+	if err == git.ErrTagExists {
+		return nil, ErrSnapshotExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return self.makeSnapshot(ref)
+}
+
+/*
+ List all snapshots, ordered by creation time
+*/
+func (self *Repository) Snapshots() ([]*Snapshot, error) {
+	return self.SnapshotsContext(context.Background())
+}
+
+func (self *Repository) SnapshotsContext(
+	ctx context.Context,
+) ([]*Snapshot, error) {
+	snapshots := []*Snapshot{}
+	if err := ctx.Err(); err != nil {
+		return snapshots, err
+	}
+
+	tags, err := self.gitRepo.Tags()
+	if err != nil {
+		return snapshots, err
+	}
+	defer tags.Close()
+
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		snapshot, err := self.makeSnapshot(ref)
+		if err == plumbing.ErrObjectNotFound {
+			// Lightweight tags are not snapshots
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	if err != nil {
+		return snapshots, err
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].CreatedAt.Equal(snapshots[j].CreatedAt) {
+			return snapshots[i].Name < snapshots[j].Name
+		}
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+/*
+ Make a snapshot from an annotated tag reference
+*/
+func (self *Repository) makeSnapshot(ref *plumbing.Reference) (*Snapshot, error) {
+	tag, err := self.gitRepo.TagObject(ref.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := tag.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{
+		Name:      ref.Name().Short(),
+		Message:   strings.TrimSpace(tag.Message),
+		Tagger:    formatSignature(tag.Tagger),
+		CreatedAt: tag.Tagger.When.UTC(),
+		Commit:    makeCommit(commit),
+	}
+
+	return snapshot, nil
+}
@@ -0,0 +1,110 @@
+package gitbase
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRepositorySnapshots(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	collection, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := collection.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	archive.Put("source.lua", []byte("x = 1"), "add source")
+	archive.Put("config.lua", []byte("y = 1"), "add config")
+
+	snapshot, err := repo.Snapshot(
+		"release-41", "Release 41", WithAuthor("Jane Doe", "jane@example.com"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if snapshot.Message != "Release 41" {
+		t.Error("Unexpected message:", snapshot.Message)
+	}
+
+	archive.Put("source.lua", []byte("x = 2"), "update source")
+	archive.Remove("config.lua", "remove config")
+
+	if _, err := repo.Snapshot("release-42", ""); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Errors
+	if _, err := repo.Snapshot("release-42", ""); err != ErrSnapshotExists {
+		t.Error("Expected ErrSnapshotExists, got:", err)
+	}
+	if _, err := repo.Snapshot("release 42", ""); err != ErrInvalidSnapshotName {
+		t.Error("Expected ErrInvalidSnapshotName, got:", err)
+	}
+
+	snapshots, err := repo.Snapshots()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(snapshots) != 2 {
+		t.Error("Expected 2 snapshots, got:", len(snapshots))
+		return
+	}
+
+	history, err := repo.History(".")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if snapshots[1].Name != "release-42" ||
+		snapshots[1].Commit.Id != history[0].Id {
+		t.Error("Unexpected snapshot:", snapshots[1])
+	}
+	if snapshots[0].Name != "release-41" ||
+		snapshots[0].Commit.Id != history[2].Id {
+		t.Error("Unexpected snapshot:", snapshots[0])
+	}
+	if !strings.HasPrefix(snapshots[0].Tagger, "Jane Doe <jane@example.com>") {
+		t.Error("Unexpected tagger:", snapshots[0].Tagger)
+	}
+
+	// Retrieve documents by snapshot name
+	res, err := archive.FetchRevision("source.lua", "release-41")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "x = 1" {
+		t.Error("Expected: x = 1, got:", string(res))
+	}
+
+	documents, err := archive.DocumentsRevision("release-41")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(documents) != 2 {
+		t.Error("Expected 2 documents, got:", documents)
+	}
+
+	documents, err = archive.DocumentsRevision("release-42")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(documents) != 1 || documents[0] != "source.lua" {
+		t.Error("Expected only source.lua, got:", documents)
+	}
+}
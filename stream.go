@@ -0,0 +1,251 @@
+package gitbase
+
+/*
+Streaming documents:
+Large documents can be written from a reader and read
+as a stream from the worktree or the git object storage,
+without holding the entire document in memory.
+
+Example:
+
+    f, err := os.Open("assets.tar")
+    err = archive.PutReader("assets.tar", f, "update assets")
+
+    reader, err := archive.OpenRevision("assets.tar", "release-42")
+    defer reader.Close()
+
+If pre-write hooks match the key, the document is read
+into memory to run the hooks.
+*/
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+/*
+ Create or update a document from a reader
+*/
+func (self *Repository) PutReader(
+	key string, reader io.Reader, reason string, opts ...WriteOption,
+) error {
+	return self.PutReaderContext(
+		context.Background(), key, reader, reason, opts...)
+}
+
+func (self *Repository) PutReaderContext(
+	ctx context.Context,
+	key string,
+	reader io.Reader,
+	reason string,
+	opts ...WriteOption,
+) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	// Hooks work on the entire document
+	if self.Hooks().matchPreWrite(key) {
+		document, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		return self.PutContext(ctx, key, document, reason, opts...)
+	}
+
+	if err := self.lockContext(ctx); err != nil {
+		return err
+	}
+	defer self.unlock()
+
+	if err := self.writeDocumentReader(key, reader); err != nil {
+		return err
+	}
+
+	return self.commitOrRestore(reason, []string{key}, opts...)
+}
+
+/*
+ Write the document to the worktree without committing.
+ The caller must hold the repository lock.
+*/
+func (self *Repository) writeDocumentReader(key string, reader io.Reader) error {
+	// Encryption requires the entire document
+	if self.keyProvider(key) != nil {
+		document, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		return self.writeDocument(key, document)
+	}
+
+	reader, err := escapeReader(reader)
+	if err != nil {
+		return err
+	}
+
+	if self.BlobThreshold > 0 {
+		document, err := self.offloadReader(reader)
+		if err != nil {
+			return err
+		}
+		return self.writeStoredDocument(key, document)
+	}
+
+	path := filepath.Join(self.BasePath, key)
+	return writeFileAtomic(path, reader)
+}
+
+/*
+ Open a document for reading. The caller must
+ close the reader.
+*/
+func (self *Repository) OpenDocument(key string) (io.ReadCloser, error) {
+	return self.OpenDocumentContext(context.Background(), key)
+}
+
+func (self *Repository) OpenDocumentContext(
+	ctx context.Context, key string,
+) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if self.commit != nil {
+		tree, err := self.commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+		reader, err := openTreeDocument(tree, key)
+		if err != nil {
+			return nil, err
+		}
+		return self.decodeReader(key, reader)
+	}
+
+	reader, err := os.Open(filepath.Join(self.BasePath, key))
+	if err != nil {
+		return nil, err
+	}
+
+	return self.decodeReader(key, reader)
+}
+
+/*
+ Open a specific version of a document for reading,
+ see FetchRevision. The caller must close the reader.
+*/
+func (self *Repository) OpenDocumentRevision(
+	key, rev string,
+) (io.ReadCloser, error) {
+	return self.OpenDocumentRevisionContext(context.Background(), key, rev)
+}
+
+func (self *Repository) OpenDocumentRevisionContext(
+	ctx context.Context, key, rev string,
+) (io.ReadCloser, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	tree, err := self.revisionTree(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := openTreeDocument(tree, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return self.decodeReader(key, reader)
+}
+
+/*
+ Create / Update document from a reader, see Repository.PutReader
+*/
+func (self *Archive) PutReader(
+	key string, reader io.Reader, reason string, opts ...WriteOption,
+) error {
+	return self.PutReaderContext(
+		context.Background(), key, reader, reason, opts...)
+}
+
+func (self *Archive) PutReaderContext(
+	ctx context.Context,
+	key string,
+	reader io.Reader,
+	reason string,
+	opts ...WriteOption,
+) error {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return err
+	}
+	return self.Collection.Repository.PutReaderContext(
+		ctx, path, reader, reason, opts...)
+}
+
+/*
+ Open document, see Repository.OpenDocument
+*/
+func (self *Archive) Open(key string) (io.ReadCloser, error) {
+	return self.OpenContext(context.Background(), key)
+}
+
+func (self *Archive) OpenContext(
+	ctx context.Context, key string,
+) (io.ReadCloser, error) {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return self.Collection.Repository.OpenDocumentContext(ctx, path)
+}
+
+/*
+ Open document revision, see Repository.OpenDocumentRevision
+*/
+func (self *Archive) OpenRevision(key, rev string) (io.ReadCloser, error) {
+	return self.OpenRevisionContext(context.Background(), key, rev)
+}
+
+func (self *Archive) OpenRevisionContext(
+	ctx context.Context, key, rev string,
+) (io.ReadCloser, error) {
+	path, err := self.documentKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return self.Collection.Repository.OpenDocumentRevisionContext(
+		ctx, path, rev)
+}
+
+/*
+ Resolve pointers and decrypt or unescape a stored
+ document stream
+*/
+func (self *Repository) decodeReader(
+	key string, reader io.ReadCloser,
+) (io.ReadCloser, error) {
+	reader, err := self.resolveReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, escaped, err := unescapeReader(reader)
+	if err != nil || escaped {
+		return reader, err
+	}
+
+	return self.decryptReader(key, reader)
+}
@@ -0,0 +1,118 @@
+package gitbase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestArchiveStreaming(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	assets, err := repo.Use("assets")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := assets.NextArchive("new assets")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	err = archive.PutReader("blob.bin", bytes.NewReader(large), "add blob")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rev, err := repo.headRevision()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = archive.PutReader("blob.bin", strings.NewReader("small"), "shrink blob")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	reader, err := archive.Open("blob.bin")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "small" {
+		t.Error("Expected small, got:", string(res))
+	}
+
+	reader, err = archive.OpenRevision("blob.bin", rev)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err = ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	if !bytes.Equal(res, large) {
+		t.Error("Expected large blob, got", len(res), "bytes")
+	}
+
+	if _, err := archive.OpenRevision("missing.bin", rev); err != ErrDocumentNotFound {
+		t.Error("Expected ErrDocumentNotFound, got:", err)
+	}
+	if _, err := archive.Open("missing.bin"); !os.IsNotExist(err) {
+		t.Error("Expected not exist error, got:", err)
+	}
+
+	// Read-only views stream from the commit
+	view, err := repo.At(rev)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reader, err = view.OpenDocument(archive.Key("blob.bin"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, _ = ioutil.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(res, large) {
+		t.Error("Expected large blob in view, got", len(res), "bytes")
+	}
+
+	// Pre-write hooks are applied to streamed documents
+	repo.Hooks().PreWrite("assets",
+		func(key string, document []byte) ([]byte, error) {
+			return bytes.ToUpper(document), nil
+		})
+
+	err = archive.PutReader("blob.bin", strings.NewReader("small"), "update")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, _ = archive.Fetch("blob.bin")
+	if string(res) != "SMALL" {
+		t.Error("Expected transformed document, got:", string(res))
+	}
+}
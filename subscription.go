@@ -0,0 +1,283 @@
+package gitbase
+
+/*
+Change notifications:
+Subscribers receive a change event for every changed
+path of a commit made through the repository.
+
+Example:
+
+    sub := repo.Subscribe(SubscriptionFilter{Collection: "programs"})
+    defer sub.Close()
+
+    for event := range sub.Events {
+        ...
+    }
+
+Delivery does not block: if the buffer of a subscription
+is full, events are dropped and counted. A subscriber can
+check Dropped() and reload its state.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const SubscriptionBufferSize = 64
+
+type ChangeOperation int
+
+const (
+	ChangePut ChangeOperation = iota
+	ChangeRemove
+	ChangeCreateCollection
+	ChangeDestroyCollection
+	ChangeCreateArchive
+	ChangeDestroyArchive
+)
+
+func (self ChangeOperation) String() string {
+	switch self {
+	case ChangePut:
+		return "put"
+	case ChangeRemove:
+		return "remove"
+	case ChangeCreateCollection:
+		return "create_collection"
+	case ChangeDestroyCollection:
+		return "destroy_collection"
+	case ChangeCreateArchive:
+		return "create_archive"
+	case ChangeDestroyArchive:
+		return "destroy_archive"
+	}
+	return "unknown"
+}
+
+/*
+ A change event describes a changed path in a commit.
+ Collection, ArchiveId and Document are derived from
+ the key, if applicable.
+*/
+type ChangeEvent struct {
+	CommitId string
+
+	Key        string
+	Collection string
+	ArchiveId  uint64
+	Document   string
+
+	Operation ChangeOperation
+}
+
+/*
+ Only receive events of a collection or an archive.
+ Empty values match everything.
+*/
+type SubscriptionFilter struct {
+	Collection string
+	ArchiveId  uint64
+}
+
+func (self SubscriptionFilter) Match(event *ChangeEvent) bool {
+	if self.Collection != "" && self.Collection != event.Collection {
+		return false
+	}
+	if self.ArchiveId != 0 && self.ArchiveId != event.ArchiveId {
+		return false
+	}
+	return true
+}
+
+type Subscription struct {
+	Events <-chan *ChangeEvent
+
+	events  chan *ChangeEvent
+	filter  SubscriptionFilter
+	dropped uint64
+
+	repo *Repository
+}
+
+/*
+ Number of events dropped, because the
+ subscriber was too slow.
+*/
+func (self *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&self.dropped)
+}
+
+/*
+ Stop receiving events, the events channel is closed.
+*/
+func (self *Subscription) Close() {
+	self.repo.subscriptions.remove(self)
+}
+
+type subscriptionList struct {
+	sync.Mutex
+	subscriptions []*Subscription
+}
+
+func (self *subscriptionList) add(subscription *Subscription) {
+	self.Lock()
+	defer self.Unlock()
+
+	self.subscriptions = append(self.subscriptions, subscription)
+}
+
+func (self *subscriptionList) remove(subscription *Subscription) {
+	self.Lock()
+	defer self.Unlock()
+
+	for i, s := range self.subscriptions {
+		if s == subscription {
+			self.subscriptions = append(
+				self.subscriptions[:i], self.subscriptions[i+1:]...)
+			close(s.events)
+			return
+		}
+	}
+}
+
+func (self *subscriptionList) empty() bool {
+	self.Lock()
+	defer self.Unlock()
+
+	return len(self.subscriptions) == 0
+}
+
+/*
+ Deliver events without blocking
+*/
+func (self *subscriptionList) publish(events []*ChangeEvent) {
+	self.Lock()
+	defer self.Unlock()
+
+	for _, subscription := range self.subscriptions {
+		for _, event := range events {
+			if !subscription.filter.Match(event) {
+				continue
+			}
+
+			select {
+			case subscription.events <- event:
+			default:
+				atomic.AddUint64(&subscription.dropped, 1)
+			}
+		}
+	}
+}
+
+/*
+ Subscribe to changes in the repository
+*/
+func (self *Repository) Subscribe(filter SubscriptionFilter) *Subscription {
+	events := make(chan *ChangeEvent, SubscriptionBufferSize)
+	subscription := &Subscription{
+		Events: events,
+		events: events,
+		filter: filter,
+		repo:   self,
+	}
+
+	self.subscriptions.add(subscription)
+
+	return subscription
+}
+
+/*
+ Get the changes between two commits as events, the
+ events refer to the newer commit. If from is nil,
+ all paths of the commit are included.
+*/
+func changeEvents(from, to *object.Commit) ([]*ChangeEvent, error) {
+	fromTree := &object.Tree{}
+	if from != nil {
+		tree, err := from.Tree()
+		if err != nil {
+			return nil, err
+		}
+		fromTree = tree
+	}
+
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	commitId := to.Hash.String()
+	events := make([]*ChangeEvent, 0, len(changes))
+	for _, change := range changes {
+		key := change.To.Name
+		removed := key == ""
+		if removed {
+			key = change.From.Name
+		}
+
+		events = append(events, makeChangeEvent(commitId, key, removed))
+	}
+
+	return events, nil
+}
+
+/*
+ Derive collection, archive and operation from a key
+*/
+func makeChangeEvent(commitId, key string, removed bool) *ChangeEvent {
+	event := &ChangeEvent{
+		CommitId:  commitId,
+		Key:       key,
+		Operation: ChangePut,
+	}
+	if removed {
+		event.Operation = ChangeRemove
+	}
+
+	components := strings.Split(key, "/")
+	if len(components) < 2 {
+		// A document in the repository root
+		event.Document = key
+		return event
+	}
+
+	event.Collection = components[0]
+	name := path.Base(key)
+
+	if len(components) == 2 && name == ".gitkeep" {
+		event.Operation = ChangeCreateCollection
+		if removed {
+			event.Operation = ChangeDestroyCollection
+		}
+		return event
+	}
+
+	archiveId, err := strconv.ParseUint(components[1], 10, 64)
+	if err != nil {
+		return event
+	}
+	event.ArchiveId = archiveId
+
+	if len(components) == 3 && name == ".gitkeep" {
+		event.Operation = ChangeCreateArchive
+		if removed {
+			event.Operation = ChangeDestroyArchive
+		}
+		return event
+	}
+
+	event.Document = strings.Join(components[2:], "/")
+
+	return event
+}
@@ -0,0 +1,158 @@
+package gitbase
+
+import (
+	"os"
+	"testing"
+)
+
+func receiveEvents(sub *Subscription) []*ChangeEvent {
+	events := []*ChangeEvent{}
+	for {
+		select {
+		case event := <-sub.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestRepositorySubscribe(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	all := repo.Subscribe(SubscriptionFilter{})
+	defer all.Close()
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	events := receiveEvents(all)
+	if len(events) != 1 ||
+		events[0].Operation != ChangeCreateCollection ||
+		events[0].Collection != "programs" {
+		t.Error("Unexpected events:", events)
+	}
+
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	other, err := programs.NextArchive("other program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	events = receiveEvents(all)
+	if len(events) != 2 ||
+		events[0].Operation != ChangeCreateArchive ||
+		events[0].ArchiveId != archive.Id {
+		t.Error("Unexpected events:", events)
+	}
+
+	filtered := repo.Subscribe(SubscriptionFilter{
+		Collection: "programs",
+		ArchiveId:  archive.Id,
+	})
+	defer filtered.Close()
+
+	archive.Put("source.lua", []byte("x = 1"), "add source")
+	other.Put("source.lua", []byte("y = 1"), "add other source")
+	archive.Remove("source.lua", "remove source")
+
+	events = receiveEvents(filtered)
+	if len(events) != 2 {
+		t.Error("Expected 2 events, got:", events)
+		return
+	}
+
+	rev, _ := repo.headRevision()
+	if events[1].CommitId != rev {
+		t.Error("Unexpected commit id:", events[1].CommitId)
+	}
+
+	if events[0].Operation != ChangePut ||
+		events[0].Document != "source.lua" ||
+		events[0].Key != archive.Key("source.lua") {
+		t.Error("Unexpected put event:", events[0])
+	}
+	if events[1].Operation != ChangeRemove {
+		t.Error("Unexpected remove event:", events[1])
+	}
+
+	if len(receiveEvents(all)) != 3 {
+		t.Error("Expected 3 unfiltered events")
+	}
+
+	// Closing a subscription closes the channel
+	filtered.Close()
+	if _, ok := <-filtered.Events; ok {
+		t.Error("Expected closed channel")
+	}
+
+	// Slow consumers do not block writes
+	for i := 0; i < SubscriptionBufferSize+1; i++ {
+		if err := archive.Put("source.lua", []byte{byte(i)}, ""); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if all.Dropped() != 1 {
+		t.Error("Expected 1 dropped event, got:", all.Dropped())
+	}
+	receiveEvents(all)
+
+	if err := programs.Destroy("remove programs"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	destroyed := false
+	for _, event := range receiveEvents(all) {
+		if event.Operation == ChangeDestroyCollection {
+			destroyed = true
+		}
+	}
+	if !destroyed {
+		t.Error("Expected destroy collection event")
+	}
+}
+
+func TestMakeChangeEvent(t *testing.T) {
+	cases := []struct {
+		key       string
+		removed   bool
+		operation ChangeOperation
+		archiveId uint64
+		document  string
+	}{
+		{"programs/.gitkeep", false, ChangeCreateCollection, 0, ""},
+		{"programs/.gitkeep", true, ChangeDestroyCollection, 0, ""},
+		{"programs/23/.gitkeep", false, ChangeCreateArchive, 23, ""},
+		{"programs/23/.gitkeep", true, ChangeDestroyArchive, 23, ""},
+		{"programs/23/source.lua", false, ChangePut, 23, "source.lua"},
+		{"programs/23/lib/util.lua", true, ChangeRemove, 23, "lib/util.lua"},
+		{"programs/config", false, ChangePut, 0, ""},
+	}
+
+	for _, c := range cases {
+		event := makeChangeEvent("rev", c.key, c.removed)
+		if event.Operation != c.operation ||
+			event.Collection != "programs" ||
+			event.ArchiveId != c.archiveId ||
+			event.Document != c.document {
+			t.Error("Unexpected event for", c.key, event)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package gitbase
+
+/*
+Commit metadata:
+Metadata passed to a write operation is appended to the
+commit message as git trailers, e.g.
+
+    archive.Put("source.lua", source, "update source",
+        WithMetadata(map[string]string{
+            "Request-Id": "4242",
+            "User-Id":    "23",
+        }))
+
+results in the commit message
+
+    update source
+
+    Request-Id: 4242
+    User-Id: 23
+
+The trailers of commits are parsed in the History.
+*/
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrInvalidTrailer = errors.New("invalid trailer")
+)
+
+/*
+ Attach metadata to a change, the metadata is
+ stored as git trailers in the commit message.
+*/
+func WithMetadata(metadata map[string]string) WriteOption {
+	return func(options *writeOptions) {
+		if options.trailers == nil {
+			options.trailers = map[string]string{}
+		}
+		for key, value := range metadata {
+			options.trailers[key] = value
+		}
+	}
+}
+
+/*
+ Trailer keys consist of alphanumeric characters
+ and dashes, and start with an alphanumeric character.
+*/
+func isTrailerKey(key string) bool {
+	if key == "" || key[0] == '-' {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' ||
+			c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' ||
+			c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ Append the trailers to a commit message,
+ ordered by key.
+*/
+func formatMessage(reason string, trailers map[string]string) (string, error) {
+	if len(trailers) == 0 {
+		return reason, nil
+	}
+
+	keys := make([]string, 0, len(trailers))
+	for key, value := range trailers {
+		if !isTrailerKey(key) || strings.ContainsAny(value, "\r\n") {
+			return "", ErrInvalidTrailer
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, key+": "+strings.TrimSpace(trailers[key]))
+	}
+
+	return strings.TrimSpace(reason) + "\n\n" + strings.Join(lines, "\n"), nil
+}
+
+/*
+ Parse a trailer block. All lines must be trailers.
+*/
+func parseTrailers(paragraph string) (map[string]string, bool) {
+	trailers := map[string]string{}
+	for _, line := range strings.Split(paragraph, "\n") {
+		tokens := strings.SplitN(line, ":", 2)
+		if len(tokens) != 2 || !isTrailerKey(tokens[0]) {
+			return nil, false
+		}
+		trailers[tokens[0]] = strings.TrimSpace(tokens[1])
+	}
+
+	return trailers, true
+}
+
+/*
+ Split a commit message into subject, body and trailers.
+ The trailers are the last paragraph of the message,
+ if it consists only of trailers.
+*/
+func splitMessage(message string) (string, string, map[string]string) {
+	message = strings.TrimSpace(message)
+	trailers := map[string]string{}
+
+	paragraphs := strings.Split(message, "\n\n")
+	subject := strings.TrimSpace(paragraphs[0])
+	paragraphs = paragraphs[1:]
+
+	if len(paragraphs) > 0 {
+		last := strings.TrimSpace(paragraphs[len(paragraphs)-1])
+		if parsed, ok := parseTrailers(last); ok {
+			trailers = parsed
+			paragraphs = paragraphs[:len(paragraphs)-1]
+		}
+	}
+
+	body := strings.TrimSpace(strings.Join(paragraphs, "\n\n"))
+
+	return subject, body, trailers
+}
+
+/*
+ Set subject, body and trailers from the message
+*/
+func (self *Commit) parseMessage() {
+	self.Subject, self.Body, self.Trailers = splitMessage(self.Message)
+}
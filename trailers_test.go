@@ -0,0 +1,98 @@
+package gitbase
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	subject, body, trailers := splitMessage(
+		"update source\n\nFixed a typo.\n\nRequest-Id: 42\nUser-Id: 23\n")
+	if subject != "update source" {
+		t.Error("Unexpected subject:", subject)
+	}
+	if body != "Fixed a typo." {
+		t.Error("Unexpected body:", body)
+	}
+	if len(trailers) != 2 ||
+		trailers["Request-Id"] != "42" ||
+		trailers["User-Id"] != "23" {
+		t.Error("Unexpected trailers:", trailers)
+	}
+
+	// A paragraph with other lines is not a trailer block
+	subject, body, trailers = splitMessage(
+		"update source\n\nNote: this is not\na trailer block")
+	if subject != "update source" ||
+		body != "Note: this is not\na trailer block" ||
+		len(trailers) != 0 {
+		t.Error("Unexpected split:", subject, body, trailers)
+	}
+
+	// The subject is never a trailer
+	subject, body, trailers = splitMessage("Request-Id: 42")
+	if subject != "Request-Id: 42" || body != "" || len(trailers) != 0 {
+		t.Error("Unexpected split:", subject, body, trailers)
+	}
+}
+
+func TestRepositoryCommitMetadata(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	metadata := map[string]string{
+		"User-Id":    "23",
+		"Request-Id": "4242",
+	}
+	err = repo.Put("doc", []byte("data"), "update doc", WithMetadata(metadata))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, backend := range []HistoryBackend{HistoryNative, HistoryGitCLI} {
+		repo.HistoryBackend = backend
+
+		history, err := repo.History("doc")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(history) != 1 {
+			t.Error("Expected 1 commit, got:", len(history))
+			return
+		}
+
+		commit := history[0]
+		if commit.Subject != "update doc" || commit.Body != "" {
+			t.Error("Unexpected subject or body:", commit.Subject, commit.Body)
+		}
+		if commit.Trailers["User-Id"] != "23" ||
+			commit.Trailers["Request-Id"] != "4242" {
+			t.Error("Unexpected trailers:", commit.Trailers)
+		}
+		if commit.Message != "update doc\n\nRequest-Id: 4242\nUser-Id: 23" {
+			t.Error("Unexpected message:", commit.Message)
+		}
+	}
+
+	// Invalid trailers are rejected
+	err = repo.Commit("invalid", WithMetadata(map[string]string{
+		"Bad Key": "value",
+	}))
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+	err = repo.Commit("invalid", WithMetadata(map[string]string{
+		"Key": "multi\nline",
+	}))
+	if err != ErrInvalidTrailer {
+		t.Error("Expected ErrInvalidTrailer, got:", err)
+	}
+}
@@ -0,0 +1,221 @@
+package gitbase
+
+/*
+Transactions group multiple changes into a single commit.
+
+Example:
+
+    err := repo.Transaction("update program", func(tx *Tx) error {
+        archive, err := tx.NextArchive(programs)
+        if err != nil {
+            return err
+        }
+
+        return tx.Put(archive.Key("source.lua"), source)
+    })
+
+If the callback returns an error or panics, all changes
+made within the transaction are rolled back in the worktree.
+
+The repository is locked while the callback runs. Only
+the methods of tx may be used to change the repository
+within the callback, calling e.g. repo.Put deadlocks.
+*/
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+type Tx struct {
+	repo *Repository
+
+	// Paths touched in the worktree, relative to
+	// the repository base path
+	paths []string
+
+	// Directories created within the transaction
+	dirs []string
+}
+
+/*
+ Run a transaction and commit all changes at once.
+ Only the methods of tx may be used in the callback.
+*/
+func (self *Repository) Transaction(
+	reason string,
+	fn func(tx *Tx) error,
+	opts ...WriteOption,
+) error {
+	return self.TransactionContext(context.Background(), reason, fn, opts...)
+}
+
+/*
+ Run a transaction, if the context is done before
+ the changes are committed, the transaction
+ is rolled back.
+*/
+func (self *Repository) TransactionContext(
+	ctx context.Context,
+	reason string,
+	fn func(tx *Tx) error,
+	opts ...WriteOption,
+) error {
+	if err := self.lockContext(ctx); err != nil {
+		return err
+	}
+	defer self.unlock()
+
+	tx := &Tx{
+		repo: self,
+	}
+
+	if err := tx.run(fn); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		tx.rollback()
+		return err
+	}
+
+	// Nothing to commit
+	if len(tx.paths) == 0 && len(tx.dirs) == 0 {
+		return nil
+	}
+
+	// Only the touched paths are committed
+	err := self.stageDocuments(tx.paths...)
+	if err == nil {
+		err = self.Commit(reason, opts...)
+	}
+	if err != nil {
+		if !isCommitted(err) {
+			tx.rollback()
+		}
+		return err
+	}
+
+	return nil
+}
+
+/*
+ Create or update a document, see Repository.Put
+*/
+func (self *Tx) Put(key string, document []byte) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+
+	document, err := self.repo.Hooks().runPreWrite(key, document)
+	if err != nil {
+		return err
+	}
+
+	self.touch(key)
+	return self.repo.writeDocument(key, document)
+}
+
+/*
+ Remove a document, see Repository.Remove
+*/
+func (self *Tx) Remove(key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	self.touch(key)
+	return self.repo.removeDocument(key)
+}
+
+/*
+ Create a collection, see CreateCollection
+*/
+func (self *Tx) CreateCollection(name string) (*Collection, error) {
+	if err := validateCollectionName(name); err != nil {
+		return nil, err
+	}
+
+	collection := &Collection{
+		Name:       name,
+		Repository: self.repo,
+	}
+
+	self.mkdir(name)
+	self.touch(filepath.Join(name, ".gitkeep"))
+	if err := collection.createPath(); err != nil {
+		return nil, err
+	}
+
+	return collection, nil
+}
+
+/*
+ Create a new archive, see NextArchive
+*/
+func (self *Tx) NextArchive(collection *Collection) (*Archive, error) {
+	nextId := NextArchiveId(collection)
+
+	archive := &Archive{
+		Id:         nextId,
+		Collection: collection,
+	}
+
+	self.mkdir(archive.Key(""))
+	self.touch(archive.Key(".gitkeep"))
+	if err := createArchivePath(collection, nextId); err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+/*
+ Run the callback, roll back and re-panic
+ if the callback panics.
+*/
+func (self *Tx) run(fn func(tx *Tx) error) error {
+	defer func() {
+		if r := recover(); r != nil {
+			self.rollback()
+			panic(r)
+		}
+	}()
+
+	return fn(self)
+}
+
+func (self *Tx) touch(key string) {
+	self.paths = append(self.paths, key)
+}
+
+/*
+ Remember a directory, if it does not exist yet
+*/
+func (self *Tx) mkdir(key string) {
+	path := filepath.Join(self.repo.BasePath, key)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		self.dirs = append(self.dirs, key)
+	}
+}
+
+/*
+ Restore all touched paths to the state of HEAD
+*/
+func (self *Tx) rollback() {
+	// Without a HEAD tree, all touched paths are new
+	tree, _ := self.repo.headTree()
+
+	for _, key := range self.paths {
+		self.repo.restoreDocument(tree, key)
+	}
+
+	// Remove created directories in reverse order
+	for i := len(self.dirs) - 1; i >= 0; i-- {
+		os.RemoveAll(filepath.Join(self.repo.BasePath, self.dirs[i]))
+	}
+
+	// Reset the index of the touched paths
+	self.repo.stageDocuments(self.paths...)
+}
@@ -0,0 +1,186 @@
+package gitbase
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestTransactionCommit(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	var archive *Archive
+	err = repo.Transaction("create program", func(tx *Tx) error {
+		programs, err := tx.CreateCollection("programs")
+		if err != nil {
+			return err
+		}
+
+		archive, err = tx.NextArchive(programs)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Put(archive.Key("source.lua"), []byte("x = 1")); err != nil {
+			return err
+		}
+		if err := tx.Put(archive.Key("README"), []byte("test")); err != nil {
+			return err
+		}
+		return tx.Remove(archive.Key("README"))
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	history, err := repo.History(".")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(history) != 1 {
+		t.Error("Expected a single commit, got:", len(history))
+	}
+	if history[0].Message != "create program" {
+		t.Error("Unexpected commit message:", history[0].Message)
+	}
+
+	source, err := archive.Fetch("source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(source) != "x = 1" {
+		t.Error("Unexpected document:", string(source))
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := archive.Put("source.lua", []byte("x = 1"), "add source"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	errAbort := errors.New("abort")
+	err = repo.Transaction("broken change", func(tx *Tx) error {
+		if err := tx.Put(archive.Key("source.lua"), []byte("x = 2")); err != nil {
+			return err
+		}
+		if err := tx.Put(archive.Key("new.lua"), []byte("y = 1")); err != nil {
+			return err
+		}
+		if _, err := tx.NextArchive(programs); err != nil {
+			return err
+		}
+		if _, err := tx.CreateCollection("assets"); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Error("Expected errAbort, got:", err)
+	}
+
+	// Everything should be as before
+	source, err := archive.Fetch("source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(source) != "x = 1" {
+		t.Error("Expected source to be restored, got:", string(source))
+	}
+
+	if _, err := archive.Fetch("new.lua"); err == nil {
+		t.Error("Expected new.lua to be removed")
+	}
+	if _, err := programs.Find(2); err != ErrArchiveDoesNotExist {
+		t.Error("Expected archive 2 to be removed, got:", err)
+	}
+	if _, err := repo.Open("assets"); err != ErrCollectionDoesNotExist {
+		t.Error("Expected collection to be removed, got:", err)
+	}
+
+	status, err := repo.Worktree.Status()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !status.IsClean() {
+		t.Error("Expected clean worktree, got:", status)
+	}
+}
+
+func TestTransactionPanic(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+	if err := repo.Put("doc", []byte("committed"), "add doc"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Error("Expected panic to be passed on, got:", r)
+			}
+		}()
+		repo.Transaction("broken change", func(tx *Tx) error {
+			if err := tx.Put("doc", []byte("changed")); err != nil {
+				return err
+			}
+			if err := tx.Put("new", []byte("new")); err != nil {
+				return err
+			}
+			panic("boom")
+		})
+	}()
+
+	res, _ := repo.Fetch("doc")
+	if string(res) != "committed" {
+		t.Error("Expected document to be restored, got:", string(res))
+	}
+	if _, err := repo.Fetch("new"); err == nil {
+		t.Error("Expected new document to be removed")
+	}
+
+	// The lock is released
+	if err := repo.Put("doc", []byte("updated"), "update doc"); err != nil {
+		t.Error(err)
+	}
+	paths, _ := repo.dirtyPaths()
+	if len(paths) != 0 {
+		t.Error("Expected clean worktree, got:", paths)
+	}
+}
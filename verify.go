@@ -0,0 +1,575 @@
+package gitbase
+
+/*
+Integrity checks:
+Verify checks the repository and reports
+
+  * layout violations, like missing .gitkeep files or
+    unexpected entries in collections and archives
+  * uncommitted changes in the worktree
+  * broken or missing git objects reachable from any
+    branch or tag, and missing blobs of offloaded documents
+  * archive id anomalies, like leading zeros or duplicates
+
+Repair fixes what can be fixed and commits all repairs
+at once: missing .gitkeep files are created, leftover
+temporary files are removed and uncommitted changes
+the repository could have written are committed.
+Like DirtyWorktreeCommit, other new files are left
+untouched.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/filemode"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type IssueKind int
+
+const (
+	IssueMissingGitkeep IssueKind = iota
+	IssueUnexpectedEntry
+	IssueUncommitted
+	IssueBrokenObject
+	IssueMissingBlob
+	IssueInvalidArchiveId
+	IssueDuplicateArchiveId
+)
+
+func (self IssueKind) String() string {
+	switch self {
+	case IssueMissingGitkeep:
+		return "missing_gitkeep"
+	case IssueUnexpectedEntry:
+		return "unexpected_entry"
+	case IssueUncommitted:
+		return "uncommitted"
+	case IssueBrokenObject:
+		return "broken_object"
+	case IssueMissingBlob:
+		return "missing_blob"
+	case IssueInvalidArchiveId:
+		return "invalid_archive_id"
+	case IssueDuplicateArchiveId:
+		return "duplicate_archive_id"
+	}
+	return "unknown"
+}
+
+/*
+ A problem found by Verify. The path is relative to
+ the repository, for broken objects it is the name of
+ the reference or the hash of the object.
+*/
+type VerifyIssue struct {
+	Kind   IssueKind
+	Path   string
+	Detail string
+
+	Repairable bool
+	Repaired   bool
+}
+
+func (self *VerifyIssue) String() string {
+	return self.Kind.String() + " " + self.Path + ": " + self.Detail
+}
+
+type VerifyReport struct {
+	Issues []*VerifyIssue
+
+	// The commit of the repairs, if any
+	RepairCommitId string
+}
+
+/*
+ Check if there are no issues left
+*/
+func (self *VerifyReport) OK() bool {
+	for _, issue := range self.Issues {
+		if !issue.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *VerifyReport) add(
+	kind IssueKind, key, detail string, repairable bool,
+) {
+	self.Issues = append(self.Issues, &VerifyIssue{
+		Kind:       kind,
+		Path:       key,
+		Detail:     detail,
+		Repairable: repairable,
+	})
+}
+
+/*
+ Check the integrity of the repository.
+ The repository is locked only while the worktree
+ and the index are checked, the walk of the history
+ objects runs concurrently with writes.
+*/
+func (self *Repository) Verify() (*VerifyReport, error) {
+	return self.VerifyContext(context.Background())
+}
+
+func (self *Repository) VerifyContext(
+	ctx context.Context,
+) (*VerifyReport, error) {
+	if err := self.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	report, err := self.verifyWorktree()
+	self.unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := self.verifyObjects(ctx, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+/*
+ Check the integrity of the repository and
+ commit the repairs. The report lists all issues,
+ repaired issues are marked as such.
+*/
+func (self *Repository) Repair(
+	reason string, opts ...WriteOption,
+) (*VerifyReport, error) {
+	return self.RepairContext(context.Background(), reason, opts...)
+}
+
+func (self *Repository) RepairContext(
+	ctx context.Context, reason string, opts ...WriteOption,
+) (*VerifyReport, error) {
+	if err := self.lockContext(ctx); err != nil {
+		return nil, err
+	}
+	report, err := self.repairWorktree(reason, opts)
+	self.unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := self.verifyObjects(ctx, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+/*
+ Check the worktree and commit the repairs.
+ Only the repaired paths are staged, other files
+ are left untouched. The caller must hold the
+ repository lock.
+*/
+func (self *Repository) repairWorktree(
+	reason string, opts []WriteOption,
+) (*VerifyReport, error) {
+	report, err := self.verifyWorktree()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	skipped := []string{}
+	for _, issue := range report.Issues {
+		if !issue.Repairable {
+			if issue.Kind == IssueUncommitted {
+				skipped = append(skipped, issue.Path)
+			}
+			continue
+		}
+
+		path := filepath.Join(self.BasePath, issue.Path)
+		switch issue.Kind {
+		case IssueMissingGitkeep:
+			if err := ioutil.WriteFile(path, []byte{}, 0644); err != nil {
+				return nil, err
+			}
+		case IssueUncommitted:
+			// Leftovers of interrupted writes are not committed
+			if strings.HasPrefix(filepath.Base(path), atomicTempPrefix) {
+				err := os.Remove(path)
+				if err != nil && !os.IsNotExist(err) {
+					return nil, err
+				}
+				issue.Repaired = true
+				continue
+			}
+		}
+		keys = append(keys, issue.Path)
+	}
+
+	if len(keys) == 0 {
+		return report, nil
+	}
+
+	// Files staged by someone else are not committed
+	if err := self.unstagePaths(skipped...); err != nil {
+		return nil, err
+	}
+	if err := self.stageDocuments(keys...); err != nil {
+		return nil, err
+	}
+
+	// Fall back to default reason if required
+	if reason == "" {
+		reason = "repaired repository"
+	}
+
+	if err := self.Commit(reason, opts...); err != nil && !isCommitted(err) {
+		return nil, err
+	}
+
+	rev, err := self.headRevision()
+	if err != nil {
+		return nil, err
+	}
+	report.RepairCommitId = rev
+
+	for _, issue := range report.Issues {
+		if issue.Repairable {
+			issue.Repaired = true
+		}
+	}
+
+	return report, nil
+}
+
+/*
+ Check the layout and the uncommitted changes.
+ Changes are repairable if the repository could
+ have written them, see DirtyWorktreeCommit.
+ The caller must hold the repository lock.
+*/
+func (self *Repository) verifyWorktree() (*VerifyReport, error) {
+	report := &VerifyReport{}
+
+	if err := self.verifyLayout(report); err != nil {
+		return nil, err
+	}
+
+	status, err := self.Worktree.Status()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range changedPaths(status) {
+		report.add(IssueUncommitted, key, "uncommitted change",
+			isRecoverable(status, key) ||
+				strings.HasPrefix(filepath.Base(key), atomicTempPrefix))
+	}
+
+	return report, nil
+}
+
+/*
+ Read a directory of the worktree sorted by name
+*/
+func (self *Repository) readDirSorted(key string) ([]os.FileInfo, error) {
+	items, err := self.readDir(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name() < items[j].Name()
+	})
+
+	return items, nil
+}
+
+func hasGitkeep(items []os.FileInfo) bool {
+	for _, item := range items {
+		if item.Name() == ".gitkeep" && !item.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ Check the collections and archives in the worktree
+*/
+func (self *Repository) verifyLayout(report *VerifyReport) error {
+	items, err := self.readDirSorted("")
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		// This includes .git
+		if !item.IsDir() || strings.HasPrefix(item.Name(), ".") {
+			continue
+		}
+		if err := self.verifyCollection(report, item.Name()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *Repository) verifyCollection(report *VerifyReport, name string) error {
+	items, err := self.readDirSorted(name)
+	if err != nil {
+		return err
+	}
+
+	if !hasGitkeep(items) {
+		gitkeep := filepath.Join(name, ".gitkeep")
+		report.add(IssueMissingGitkeep, gitkeep,
+			"collection has no .gitkeep", isLayoutPath(gitkeep))
+	}
+
+	// Canonical names take precedence for duplicate ids
+	ids := map[uint64]string{}
+	for _, item := range items {
+		id, err := strconv.ParseUint(item.Name(), 10, 64)
+		if err == nil && item.IsDir() && strconv.FormatUint(id, 10) == item.Name() {
+			ids[id] = filepath.Join(name, item.Name())
+		}
+	}
+
+	for _, item := range items {
+		if strings.HasPrefix(item.Name(), ".") {
+			continue
+		}
+
+		key := filepath.Join(name, item.Name())
+		if !item.IsDir() {
+			report.add(IssueUnexpectedEntry, key,
+				"document outside of an archive", false)
+			continue
+		}
+
+		id, err := strconv.ParseUint(item.Name(), 10, 64)
+		if err != nil {
+			report.add(IssueUnexpectedEntry, key,
+				"non numeric entry in archives path", false)
+			continue
+		}
+
+		if id == 0 {
+			report.add(IssueInvalidArchiveId, key,
+				"archive ids start at 1", false)
+		} else if strconv.FormatUint(id, 10) != item.Name() {
+			report.add(IssueInvalidArchiveId, key,
+				fmt.Sprintf("archive id is not canonical, expected %d", id), false)
+		}
+
+		if other, ok := ids[id]; ok && other != key {
+			report.add(IssueDuplicateArchiveId, key,
+				"archive id is already used by "+other, false)
+		} else {
+			ids[id] = key
+		}
+
+		if err := self.verifyArchive(report, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *Repository) verifyArchive(report *VerifyReport, key string) error {
+	items, err := self.readDirSorted(key)
+	if err != nil {
+		return err
+	}
+
+	// Invalid archive ids are not repaired
+	if !hasGitkeep(items) {
+		gitkeep := filepath.Join(key, ".gitkeep")
+		report.add(IssueMissingGitkeep, gitkeep,
+			"archive has no .gitkeep", isLayoutPath(gitkeep))
+	}
+
+	for _, item := range items {
+		if item.IsDir() {
+			report.add(IssueUnexpectedEntry, filepath.Join(key, item.Name()),
+				"directory in archive", false)
+		}
+	}
+
+	return nil
+}
+
+/*
+ Check all objects reachable from branches and tags.
+ Blobs are checked against their hash, pointers of
+ offloaded documents must refer to an existing blob.
+*/
+func (self *Repository) verifyObjects(
+	ctx context.Context, report *VerifyReport,
+) error {
+	refs, err := self.gitRepo.References()
+	if err != nil {
+		return err
+	}
+	defer refs.Close()
+
+	commits := []*object.Commit{}
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		commit, err := self.gitRepo.CommitObject(ref.Hash())
+		if err == plumbing.ErrObjectNotFound {
+			// Annotated tag
+			tag, terr := self.gitRepo.TagObject(ref.Hash())
+			if terr == nil {
+				commit, err = tag.Commit()
+			}
+		}
+		if err != nil {
+			report.add(IssueBrokenObject, ref.Name().String(), err.Error(), false)
+			return nil
+		}
+
+		commits = append(commits, commit)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := map[plumbing.Hash]bool{}
+	for len(commits) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		commit := commits[len(commits)-1]
+		commits = commits[:len(commits)-1]
+		if seen[commit.Hash] {
+			continue
+		}
+		seen[commit.Hash] = true
+
+		tree, err := commit.Tree()
+		if err != nil {
+			report.add(IssueBrokenObject, commit.TreeHash.String(),
+				"tree of commit "+commit.Hash.String()+": "+err.Error(), false)
+		} else {
+			self.verifyTree(report, tree, "", seen)
+		}
+
+		for _, hash := range commit.ParentHashes {
+			parent, err := self.gitRepo.CommitObject(hash)
+			if err != nil {
+				report.add(IssueBrokenObject, hash.String(),
+					"parent of commit "+commit.Hash.String()+": "+err.Error(), false)
+				continue
+			}
+			commits = append(commits, parent)
+		}
+	}
+
+	return nil
+}
+
+func (self *Repository) verifyTree(
+	report *VerifyReport,
+	tree *object.Tree,
+	prefix string,
+	seen map[plumbing.Hash]bool,
+) {
+	if seen[tree.Hash] {
+		return
+	}
+	seen[tree.Hash] = true
+
+	for _, entry := range tree.Entries {
+		if seen[entry.Hash] {
+			continue
+		}
+
+		key := path.Join(prefix, entry.Name)
+		if entry.Mode == filemode.Dir {
+			subtree, err := self.gitRepo.TreeObject(entry.Hash)
+			if err != nil {
+				report.add(IssueBrokenObject, entry.Hash.String(),
+					"tree "+key+": "+err.Error(), false)
+				continue
+			}
+			self.verifyTree(report, subtree, key, seen)
+			continue
+		}
+		if !entry.Mode.IsFile() {
+			continue
+		}
+		seen[entry.Hash] = true
+
+		if err := self.verifyBlob(report, entry.Hash, key); err != nil {
+			report.add(IssueBrokenObject, entry.Hash.String(),
+				"blob "+key+": "+err.Error(), false)
+		}
+	}
+}
+
+/*
+ Check the hash of a blob, and the blob store if
+ the blob is a pointer to an offloaded document.
+*/
+func (self *Repository) verifyBlob(
+	report *VerifyReport, hash plumbing.Hash, key string,
+) error {
+	blob, err := self.gitRepo.BlobObject(hash)
+	if err != nil {
+		return err
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	head := make([]byte, maxBlobPointerSize+1)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+
+	hasher := plumbing.NewHasher(plumbing.BlobObject, blob.Size)
+	hasher.Write(head)
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return err
+	}
+	if sum := hasher.Sum(); sum != hash {
+		return fmt.Errorf("hash mismatch, content hashes to %s", sum)
+	}
+
+	pointer, ok := parseBlobPointer(head)
+	if !ok {
+		return nil
+	}
+	if _, err := os.Stat(self.blobPath(pointer.Hash)); os.IsNotExist(err) {
+		report.add(IssueMissingBlob, key,
+			"blob "+pointer.Hash+" not found in blob store", false)
+	}
+
+	return nil
+}
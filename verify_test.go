@@ -0,0 +1,235 @@
+package gitbase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/*
+ Find the issue of a kind for a path
+*/
+func findIssue(report *VerifyReport, kind IssueKind, key string) *VerifyIssue {
+	for _, issue := range report.Issues {
+		if issue.Kind == kind && issue.Path == key {
+			return issue
+		}
+	}
+	return nil
+}
+
+func TestRepositoryVerify(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := archive.Put("source.lua", []byte("print(1)"), "add source"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	report, err := repo.Verify()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !report.OK() || len(report.Issues) != 0 {
+		t.Error("Expected no issues, got:", report.Issues)
+	}
+
+	// Break the layout
+	os.Remove(filepath.Join(path, "programs", "1", ".gitkeep"))
+	os.MkdirAll(filepath.Join(path, "programs", "01"), 0755)
+	os.MkdirAll(filepath.Join(path, "programs", "latest"), 0755)
+	os.MkdirAll(filepath.Join(path, "orphans"), 0755)
+	ioutil.WriteFile(filepath.Join(path, "orphans", "notes"), []byte("notes"), 0644)
+	ioutil.WriteFile(filepath.Join(path, "programs", "1", atomicTempPrefix+"1"), []byte("x"), 0644)
+
+	report, err = repo.Verify()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := []struct {
+		kind       IssueKind
+		key        string
+		repairable bool
+	}{
+		{IssueMissingGitkeep, "programs/1/.gitkeep", true},
+		{IssueMissingGitkeep, "programs/01/.gitkeep", false},
+		{IssueMissingGitkeep, "orphans/.gitkeep", true},
+		{IssueInvalidArchiveId, "programs/01", false},
+		{IssueDuplicateArchiveId, "programs/01", false},
+		{IssueUnexpectedEntry, "programs/latest", false},
+		{IssueUnexpectedEntry, "orphans/notes", false},
+		{IssueUncommitted, "orphans/notes", false},
+		{IssueUncommitted, "programs/1/.gitkeep", true},
+		{IssueUncommitted, "programs/1/" + atomicTempPrefix + "1", true},
+	}
+	for _, e := range expected {
+		issue := findIssue(report, e.kind, e.key)
+		if issue == nil {
+			t.Error("Expected issue:", e.kind, e.key)
+			continue
+		}
+		if issue.Repairable != e.repairable {
+			t.Error("Unexpected repairable:", issue)
+		}
+	}
+	if len(report.Issues) != len(expected) {
+		t.Error("Unexpected issues:", report.Issues)
+	}
+	if report.OK() {
+		t.Error("Expected report not to be OK")
+	}
+
+	// Repair what can be repaired, files staged
+	// outside of the layout are not committed
+	if _, err := repo.Worktree.Add("orphans/notes"); err != nil {
+		t.Error(err)
+		return
+	}
+	rev, _ := repo.headRevision()
+	report, err = repo.Repair("")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.RepairCommitId == "" || report.RepairCommitId == rev {
+		t.Error("Expected repair commit, got:", report.RepairCommitId)
+	}
+	for _, issue := range report.Issues {
+		if issue.Repaired != issue.Repairable {
+			t.Error("Unexpected repaired state:", issue)
+		}
+	}
+
+	for _, key := range []string{"programs/1/.gitkeep", "orphans/.gitkeep"} {
+		if _, err := os.Stat(filepath.Join(path, key)); err != nil {
+			t.Error("Expected file to be created:", key, err)
+		}
+	}
+	tmp := filepath.Join(path, "programs", "1", atomicTempPrefix+"1")
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Error("Expected temporary file to be removed")
+	}
+
+	// Files outside of the layout are not committed
+	paths, _ := repo.dirtyPaths()
+	if !reflect.DeepEqual(paths, []string{filepath.Join("orphans", "notes")}) {
+		t.Error("Unexpected dirty paths:", paths)
+	}
+	if _, err := os.Stat(filepath.Join(path, "programs", "01", ".gitkeep")); !os.IsNotExist(err) {
+		t.Error("Expected invalid archive not to be repaired")
+	}
+	tree, _ := repo.headTree()
+	if _, err := tree.FindEntry("orphans/notes"); err == nil {
+		t.Error("Expected orphans/notes not to be committed")
+	}
+
+	// Only unrepairable issues remain
+	report, err = repo.Verify()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, issue := range report.Issues {
+		if issue.Repairable {
+			t.Error("Unexpected repairable issue:", issue)
+		}
+	}
+	if len(report.Issues) != 6 {
+		t.Error("Unexpected issues:", report.Issues)
+	}
+}
+
+func TestRepositoryVerifyObjects(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	storePath, err := ioutil.TempDir("", "gitbase-test-blobs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(storePath)
+
+	opts := DefaultRepositoryOptions()
+	opts.BlobThreshold = 1024
+	opts.BlobStorePath = storePath
+
+	repo, err := NewRepositoryWithOptions(path, opts)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	if err := repo.Put("doc", []byte("document"), "add doc"); err != nil {
+		t.Error(err)
+		return
+	}
+	large := bytes.Repeat([]byte("x"), 4096)
+	if err := repo.Put("large", large, "add large"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	tree, err := repo.headTree()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	entry, err := tree.FindEntry("doc")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Remove the loose object of the document
+	hash := entry.Hash.String()
+	err = os.Remove(filepath.Join(path, ".git", "objects", hash[:2], hash[2:]))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Remove the offloaded blob
+	stored, _ := ioutil.ReadFile(filepath.Join(path, "large"))
+	pointer, ok := parseBlobPointer(stored)
+	if !ok {
+		t.Error("Expected pointer")
+		return
+	}
+	os.Remove(repo.blobPath(pointer.Hash))
+
+	report, err := repo.Verify()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if findIssue(report, IssueBrokenObject, hash) == nil {
+		t.Error("Expected broken object, got:", report.Issues)
+	}
+	if findIssue(report, IssueMissingBlob, "large") == nil {
+		t.Error("Expected missing blob, got:", report.Issues)
+	}
+}
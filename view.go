@@ -0,0 +1,213 @@
+package gitbase
+
+/*
+Read-only views:
+A read-only view is a repository bound to a commit.
+Collections, archives and documents are read from
+the commit tree instead of the worktree.
+
+Example:
+
+    past, err := repo.AsOf(time.Now().Add(-24 * time.Hour))
+    programs, err := past.Open("programs")
+    archives, err := programs.Archives()
+
+Writing to a read-only view fails with ErrReadOnly.
+*/
+
+import (
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	ErrReadOnly = errors.New("repository view is read-only")
+)
+
+/*
+ Get a read-only view of the repository at a revision
+*/
+func (self *Repository) At(rev string) (*Repository, error) {
+	return self.AtContext(context.Background(), rev)
+}
+
+func (self *Repository) AtContext(
+	ctx context.Context, rev string,
+) (*Repository, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	commit, err := self.resolveRevision(rev)
+	if err != nil {
+		return nil, err
+	}
+
+	return self.viewAt(commit), nil
+}
+
+/*
+ Get a read-only view of the repository as it was
+ at the given time. The first parent history of
+ HEAD is used to find the commit.
+*/
+func (self *Repository) AsOf(when time.Time) (*Repository, error) {
+	return self.AsOfContext(context.Background(), when)
+}
+
+func (self *Repository) AsOfContext(
+	ctx context.Context, when time.Time,
+) (*Repository, error) {
+	commit, err := self.headCommit()
+	if err != nil {
+		return nil, err
+	}
+
+	for commit != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !commit.Committer.When.After(when) {
+			return self.viewAt(commit), nil
+		}
+
+		if commit.NumParents() == 0 {
+			break
+		}
+		commit, err = commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, ErrRevisionNotFound
+}
+
+func (self *Repository) viewAt(commit *object.Commit) *Repository {
+	return &Repository{
+		BasePath:  self.BasePath,
+		Author:    self.Author,
+		Committer: self.Committer,
+		gitRepo:   self.gitRepo,
+		gitDir:    self.gitDir,
+
+		HistoryBackend: self.HistoryBackend,
+		FollowRenames:  self.FollowRenames,
+		LockTimeout:    self.LockTimeout,
+
+		SignKey: self.SignKey,
+		Keyring: self.Keyring,
+
+		BlobThreshold: self.BlobThreshold,
+		BlobStorePath: self.blobStorePath(),
+
+		Encryption: self.Encryption,
+
+		Logger: self.Logger,
+
+		root:   self.rootRepository(),
+		commit: commit,
+	}
+}
+
+/*
+ Check if the repository is a read-only view
+*/
+func (self *Repository) IsReadOnly() bool {
+	return self.commit != nil
+}
+
+/*
+ Read the entries of a directory, relative to the
+ base path. Read-only views read the commit tree.
+*/
+func (self *Repository) readDir(key string) ([]os.FileInfo, error) {
+	if self.commit == nil {
+		f, err := os.Open(filepath.Join(self.BasePath, key))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return f.Readdir(0)
+	}
+
+	tree, err := self.commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	if key != "" && key != "." {
+		tree, err = tree.Tree(filepath.ToSlash(filepath.Clean(key)))
+		if err == object.ErrDirectoryNotFound {
+			return nil, &os.PathError{
+				Op:   "open",
+				Path: key,
+				Err:  os.ErrNotExist,
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	items := make([]os.FileInfo, 0, len(tree.Entries))
+	for _, entry := range tree.Entries {
+		items = append(items, &treeEntryInfo{
+			entry: entry,
+			when:  self.commit.Committer.When,
+		})
+	}
+
+	return items, nil
+}
+
+/*
+ Read a document from the commit tree of the view
+*/
+func (self *Repository) readViewDocument(key string) ([]byte, error) {
+	tree, err := self.commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	return readTreeDocument(tree, key)
+}
+
+/*
+ A tree entry as file info
+*/
+type treeEntryInfo struct {
+	entry object.TreeEntry
+	when  time.Time
+}
+
+func (self *treeEntryInfo) Name() string {
+	return self.entry.Name
+}
+
+func (self *treeEntryInfo) Size() int64 {
+	return 0
+}
+
+func (self *treeEntryInfo) Mode() os.FileMode {
+	mode, _ := self.entry.Mode.ToOSFileMode()
+	return mode
+}
+
+func (self *treeEntryInfo) ModTime() time.Time {
+	return self.when
+}
+
+func (self *treeEntryInfo) IsDir() bool {
+	return self.Mode().IsDir()
+}
+
+func (self *treeEntryInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,179 @@
+package gitbase
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRepositoryAt(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	programs, err := repo.Use("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive, err := programs.NextArchive("new program")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	archive.Put("source.lua", []byte("x = 1"), "add source")
+
+	rev, err := repo.headRevision()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Change everything afterwards
+	archive.Put("source.lua", []byte("x = 2"), "update source")
+	archive.Put("config.lua", []byte("y = 1"), "add config")
+	programs.NextArchive("another program")
+	repo.Use("assets")
+
+	view, err := repo.At(rev)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !view.IsReadOnly() {
+		t.Error("Expected view to be read-only")
+	}
+
+	collections, err := view.Collections()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(collections) != 1 {
+		t.Error("Expected 1 collection, got:", len(collections))
+	}
+
+	if _, err := view.Open("assets"); err != ErrCollectionDoesNotExist {
+		t.Error("Expected ErrCollectionDoesNotExist, got:", err)
+	}
+
+	viewPrograms, err := view.Open("programs")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	archives, err := viewPrograms.Archives()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(archives) != 1 {
+		t.Error("Expected 1 archive, got:", len(archives))
+	}
+	if _, err := viewPrograms.Find(2); err != ErrArchiveDoesNotExist {
+		t.Error("Expected ErrArchiveDoesNotExist, got:", err)
+	}
+
+	viewArchive, err := viewPrograms.Find(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	documents, err := viewArchive.Documents()
+	if err != nil {
+		t.Error(err)
+	}
+	if len(documents) != 1 || documents[0] != "source.lua" {
+		t.Error("Expected only source.lua, got:", documents)
+	}
+
+	res, err := viewArchive.Fetch("source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "x = 1" {
+		t.Error("Expected: x = 1, got:", string(res))
+	}
+
+	history, err := viewArchive.History("source.lua")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(history) != 1 {
+		t.Error("Expected 1 commit, got:", len(history))
+	}
+
+	// Writes are not allowed
+	if err := viewArchive.Put("source.lua", []byte("x"), "x"); err != ErrReadOnly {
+		t.Error("Expected ErrReadOnly, got:", err)
+	}
+	if err := viewArchive.Remove("source.lua", "x"); err != ErrReadOnly {
+		t.Error("Expected ErrReadOnly, got:", err)
+	}
+	if _, err := viewPrograms.NextArchive("x"); err != ErrReadOnly {
+		t.Error("Expected ErrReadOnly, got:", err)
+	}
+	if _, err := view.Create("x", "x"); err != ErrReadOnly {
+		t.Error("Expected ErrReadOnly, got:", err)
+	}
+	if err := viewPrograms.Destroy("x"); err != ErrReadOnly {
+		t.Error("Expected ErrReadOnly, got:", err)
+	}
+	if err := view.CommitAll("x"); err != ErrReadOnly {
+		t.Error("Expected ErrReadOnly, got:", err)
+	}
+}
+
+func TestRepositoryAsOf(t *testing.T) {
+	path := testRepoPath()
+	defer os.RemoveAll(path) // Clean up afterwards
+
+	repo, err := NewRepository(path)
+	if err != nil {
+		t.Error("Could not initialize repo:", err)
+		return
+	}
+
+	before := time.Now().Add(-time.Second)
+
+	repo.Put("test.doc", []byte("v1"), "first version")
+
+	// Commit timestamps have a resolution of one second
+	time.Sleep(1100 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(1100 * time.Millisecond)
+
+	repo.Put("test.doc", []byte("v2"), "second version")
+
+	view, err := repo.AsOf(between)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, err := view.Fetch("test.doc")
+	if err != nil {
+		t.Error(err)
+	}
+	if string(res) != "v1" {
+		t.Error("Expected v1, got:", string(res))
+	}
+
+	view, err = repo.AsOf(time.Now())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	res, _ = view.Fetch("test.doc")
+	if string(res) != "v2" {
+		t.Error("Expected v2, got:", string(res))
+	}
+
+	if _, err := repo.AsOf(before); err != ErrRevisionNotFound {
+		t.Error("Expected ErrRevisionNotFound, got:", err)
+	}
+}